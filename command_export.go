@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	usersExportFile   = "users.ndjson"
+	chirpsExportFile  = "chirps.ndjson"
+	likesExportFile   = "likes.ndjson"
+	historyExportFile = "chirp_history.ndjson"
+)
+
+// exportFiles are the files commandExport writes and commandImport reads, in
+// the order they are imported.
+var exportFiles = []string{usersExportFile, chirpsExportFile, likesExportFile, historyExportFile}
+
+// commandExport writes every table as newline-delimited JSON into a
+// directory, one file per table.
+func commandExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	path := flags.String("db", dbPath, "path to the database file")
+	dir := flags.String("dir", ".", "directory to write "+strings.Join(exportFiles, ", ")+" to")
+	stripPasswords := flags.Bool("strip-passwords", false, "leave password hashes out of the user export")
+	flags.Parse(args)
+
+	db, err := openDB(*path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = os.MkdirAll(*dir, 0755)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	exports := map[string]func(w io.Writer) error{
+		usersExportFile: func(w io.Writer) error {
+			return db.ExportUsers(ctx, w, *stripPasswords)
+		},
+		chirpsExportFile: func(w io.Writer) error {
+			return db.ExportChirps(ctx, w)
+		},
+		likesExportFile: func(w io.Writer) error {
+			return db.ExportLikes(ctx, w)
+		},
+		historyExportFile: func(w io.Writer) error {
+			return db.ExportHistory(ctx, w)
+		},
+	}
+	for _, name := range exportFiles {
+		err = writeExport(filepath.Join(*dir, name), exports[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExport(path string, export func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = export(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", path, err)
+	}
+	return nil
+}
+
+// commandImport loads the files written by commandExport. Missing files are
+// skipped, so a directory holding only chirps.ndjson is fine as long as the
+// authors already exist.
+func commandImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	path := flags.String("db", dbPath, "path to the database file")
+	dir := flags.String("dir", ".", "directory to read "+strings.Join(exportFiles, ", ")+" from")
+	flags.Parse(args)
+
+	// a nil *os.File in an io.Reader is not a nil io.Reader, so missing
+	// files are left as nil readers
+	readers := make([]io.Reader, len(exportFiles))
+	found := false
+	for i, name := range exportFiles {
+		f, err := openExport(filepath.Join(*dir, name))
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			defer f.Close()
+			readers[i] = f
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("none of %s found in %s", strings.Join(exportFiles, ", "), *dir)
+	}
+
+	db, err := openDB(*path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Import(context.Background(), readers[0], readers[1], readers[2], readers[3])
+	if err != nil {
+		return err
+	}
+	return printJSON(result)
+}
+
+func openExport(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return f, err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// commandFsck checks the database invariants and prints a JSON report. It
+// fails if any problem is left unrepaired, so it can gate scripts.
+func commandFsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	path := flags.String("db", dbPath, "path to the database file")
+	repair := flags.Bool("repair", false, "fix the problems that can be fixed safely")
+	flags.Parse(args)
+
+	db, err := openDB(*path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Check(context.Background(), *repair)
+	if err != nil {
+		return err
+	}
+
+	type response struct {
+		Path string `json:"path"`
+		OK   bool   `json:"ok"`
+		database.CheckReport
+	}
+	err = printJSON(response{
+		Path:        *path,
+		OK:          report.Unrepaired() == 0,
+		CheckReport: report,
+	})
+	if err != nil {
+		return err
+	}
+	if n := report.Unrepaired(); n > 0 {
+		return fmt.Errorf("%d problems found", n)
+	}
+	return nil
+}
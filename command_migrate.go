@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// commandMigrate upgrades the database file to the current schema. The
+// server does this on startup too; the command exists mostly for -dry-run.
+func commandMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	path := flags.String("db", dbPath, "path to the database file")
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing anything")
+	flags.Parse(args)
+
+	opts, err := dbOptions()
+	if err != nil {
+		return err
+	}
+	steps, err := database.Migrate(*path, *dryRun, opts...)
+	if err != nil {
+		return err
+	}
+
+	type report struct {
+		DryRun        bool                     `json:"dry_run"`
+		SchemaVersion int                      `json:"schema_version"`
+		Steps         []database.MigrationStep `json:"steps"`
+	}
+	return printJSON(report{
+		DryRun:        *dryRun,
+		SchemaVersion: database.CurrentSchemaVersion,
+		Steps:         steps,
+	})
+}
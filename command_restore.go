@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// commandRestore swaps a backup taken from /admin/backup in as the database
+// file. The backup is validated against the schema before anything is
+// touched, and the file it replaces is kept as database.json.1.
+func commandRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := flags.String("db", dbPath, "path to the database file")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: chirpy restore [-db path] <backup file>")
+	}
+
+	opts, err := dbOptions()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = database.Restore(*path, f, opts...)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", flags.Arg(0), err)
+	}
+	fmt.Printf("restored %s from %s\n", *path, flags.Arg(0))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// runCommand runs one of the chirpy subcommands instead of the server.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "migrate":
+		return commandMigrate(args)
+	case "restore":
+		return commandRestore(args)
+	case "export":
+		return commandExport(args)
+	case "import":
+		return commandImport(args)
+	case "fsck":
+		return commandFsck(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// dbOptions builds the database options from the environment.
+func dbOptions() ([]database.Option, error) {
+	opts := []database.Option{
+		database.WithEngine(database.EngineType(os.Getenv("DB_ENGINE"))),
+	}
+
+	keySpec := os.Getenv("DB_ENCRYPTION_KEYS")
+	if keyFile := os.Getenv("DB_ENCRYPTION_KEY_FILE"); keyFile != "" {
+		if keySpec != "" {
+			return nil, errors.New("set only one of DB_ENCRYPTION_KEYS and DB_ENCRYPTION_KEY_FILE")
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		keySpec = string(data)
+	}
+	if keySpec != "" {
+		keys, err := database.ParseKeyring(keySpec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, database.WithEncryption(keys))
+	}
+	return opts, nil
+}
+
+func openDB(path string) (*database.DB, error) {
+	opts, err := dbOptions()
+	if err != nil {
+		return nil, err
+	}
+	return database.NewDB(path, opts...)
+}
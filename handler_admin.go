@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/auth"
+)
+
+// middlewareAdminAuth only lets through requests carrying the admin API key.
+// With no key configured every request is rejected.
+func (cfg *apiConfig) middlewareAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apikey, err := auth.GetBearerToken(r.Header, auth.AuthTypeAPIKey)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if cfg.adminAPIKey == "" || apikey != cfg.adminAPIKey {
+			respondWithError(w, http.StatusUnauthorized, "invalid apikey")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) handlerBackup(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("chirpy-backup-%s.json", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	cfg.streamSnapshot(w, r, "backup")
+}
+
+// streamSnapshot writes a snapshot of the database as the response. If the
+// snapshot fails before anything was written the client gets an error
+// instead; once the body has started all we can do is log it and cut the
+// response short.
+func (cfg *apiConfig) streamSnapshot(w http.ResponseWriter, r *http.Request, what string) {
+	body := &countingWriter{w: w}
+	err := cfg.db.Snapshot(r.Context(), body)
+	if err == nil {
+		return
+	}
+	if body.n == 0 {
+		w.Header().Del("Content-Disposition")
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("taking %s: %s", what, err))
+		return
+	}
+	log.Printf("streaming %s: %s", what, err)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -3,21 +3,43 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"slices"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ammon134/chirpy/internal/auth"
 	"github.com/ammon134/chirpy/internal/database"
 )
 
+// chirpResponse is a chirp as the API returns it, along with the stats
+// worked out when it was read.
+type chirpResponse struct {
+	database.Chirp
+	database.ChirpStats
+}
+
+func newChirpResponse(chirp database.Chirp) chirpResponse {
+	return chirpResponse{Chirp: chirp, ChirpStats: chirp.ChirpStats}
+}
+
+func newChirpResponses(chirps []database.Chirp) []chirpResponse {
+	responses := make([]chirpResponse, 0, len(chirps))
+	for _, chirp := range chirps {
+		responses = append(responses, newChirpResponse(chirp))
+	}
+	return responses
+}
+
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string `json:"body"`
+		InReplyTo int    `json:"in_reply_to"`
+		QuoteOf   int    `json:"quote_of"`
 	}
 	params := &parameters{}
 	err := decoder.Decode(params)
@@ -38,36 +60,102 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	chirp, err := cfg.db.CreateChirp(cleanChirp(params.Body), userID)
+	chirp, err := cfg.db.CreateChirp(r.Context(), database.NewChirp{
+		Body:      cleanChirp(params.Body),
+		AuthorID:  userID,
+		InReplyTo: params.InReplyTo,
+		QuoteOf:   params.QuoteOf,
+	})
 	if err != nil {
+		if errors.Is(err, database.ErrNotExist) || errors.Is(err, database.ErrDeleted) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, chirp)
+	respondWithJSON(w, http.StatusCreated, newChirpResponse(chirp))
 }
 
+// handlerGetChirps lists chirps a page at a time once the client asks for a
+// limit or passes a cursor. Without either it responds with every chirp, as
+// it did before pagination, so older clients don't silently lose chirps.
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
-	authorIDStr := r.URL.Query().Get("author_id")
-	authorID, err := strconv.Atoi(authorIDStr)
+	query, err := parseChirpQuery(r)
 	if err != nil {
-		authorID = -1
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	authorID, err := strconv.Atoi(r.URL.Query().Get("author_id"))
+	if err == nil && authorID > 0 {
+		query.AuthorID = authorID
 	}
 
-	chirps, err := cfg.db.GetChirpsByAuthor(authorID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if !r.URL.Query().Has("limit") && !r.URL.Query().Has("cursor") {
+		chirps, err := cfg.db.QueryChirps(r.Context(), query)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, newChirpResponses(chirps))
 		return
 	}
+	cfg.respondWithChirpPage(w, r, query)
+}
 
-	sortParam := r.URL.Query().Get("sort")
-	if sortParam == "desc" {
-		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID > chirps[j].ID })
-	} else {
-		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+// parseChirpQuery reads the filtering and ordering parameters shared by the
+// endpoints listing chirps.
+func parseChirpQuery(r *http.Request) (database.ChirpQuery, error) {
+	query := database.ChirpQuery{}
+	for param, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		var err error
+		*t, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("%s must be an RFC 3339 time", param)
+		}
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	sortBy := r.URL.Query().Get("sort_by")
+	switch database.ChirpOrder(sortBy) {
+	case "", database.OrderByID, database.OrderByCreatedAt:
+		query.OrderBy = database.ChirpOrder(sortBy)
+	default:
+		return query, errors.New("sort_by must be id or created_at")
+	}
+	query.Descending = r.URL.Query().Get("sort") == "desc"
+	return query, nil
+}
+
+// respondWithChirpPage responds with the page of query's results selected
+// by the limit and cursor parameters, linking to the next one.
+func (cfg *apiConfig) respondWithChirpPage(w http.ResponseWriter, r *http.Request, query database.ChirpQuery) {
+	chirps, ok := cfg.chirpPage(w, r, query)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newChirpResponses(chirps))
+}
+
+// chirpPage returns the page of query's results selected by the limit and
+// cursor parameters and sets the link to the next one. If it returns false
+// it has already responded with the error.
+func (cfg *apiConfig) chirpPage(w http.ResponseWriter, r *http.Request, query database.ChirpQuery) ([]database.Chirp, bool) {
+	limit, err := chirpPager.prepare(r, &query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	chirps, err := cfg.db.QueryChirps(r.Context(), query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return chirpPager.finish(w, r, query, chirps, limit), true
 }
 
 func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
@@ -77,24 +165,20 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	chirp, err := cfg.db.GetChirp(idInt)
+	chirp, err := cfg.db.GetChirp(r.Context(), idInt)
 	if err != nil {
-		if errors.Is(err, database.ErrNotExist) {
-			respondWithError(w, http.StatusNotFound, err.Error())
-			return
-		} else {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+		respondWithChirpError(w, err)
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirp)
+	respondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
 	userID, err := auth.ParseForUserID(cfg.jwtSecret, r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
 	}
 
 	chirpIDStr := r.PathValue("id")
@@ -104,12 +188,10 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	chirp, err := cfg.db.GetChirp(chirpID)
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
 	if err != nil {
-		if !errors.Is(err, database.ErrNotExist) {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+		respondWithChirpError(w, err)
+		return
 	}
 
 	if chirp.AuthorID != userID {
@@ -117,7 +199,7 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.db.DeleteChirp(chirpID)
+	err = cfg.db.DeleteChirp(r.Context(), chirpID, userID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -126,6 +208,125 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 	respondWithJSON(w, http.StatusOK, http.StatusText(http.StatusOK))
 }
 
+// handlerEditChirp replaces the body of a chirp for its author. The
+// previous body is kept in the chirp's history.
+func (cfg *apiConfig) handlerEditChirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.ParseForUserID(cfg.jwtSecret, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	params := &parameters{}
+	err = decoder.Decode(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not decode request body")
+		return
+	}
+
+	// Validate chirp length
+	if len(strings.TrimSpace(params.Body)) > 140 {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+	if chirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "user does not have permission")
+		return
+	}
+
+	chirp, err = cfg.db.EditChirp(r.Context(), chirpID, cleanChirp(params.Body))
+	if err != nil {
+		if errors.Is(err, database.ErrRechirp) {
+			respondWithError(w, http.StatusBadRequest, "rechirps can't be edited")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
+}
+
+func (cfg *apiConfig) handlerGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	history, err := cfg.db.GetChirpHistory(r.Context(), chirpID)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, history)
+}
+
+// handlerRestoreChirp undeletes a chirp for its author, as long as it was
+// deleted less than chirpRestoreWindow ago.
+func (cfg *apiConfig) handlerRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.ParseForUserID(cfg.jwtSecret, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	deleted, err := cfg.db.GetDeletedChirp(r.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "no deleted chirp with that id")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "user does not have permission")
+		return
+	}
+
+	chirp, err := cfg.db.RestoreChirp(r.Context(), chirpID, time.Now().Add(-chirpRestoreWindow))
+	if err != nil {
+		if errors.Is(err, database.ErrRestoreWindowPassed) {
+			respondWithError(w, http.StatusGone, err.Error())
+			return
+		} else if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "no deleted chirp with that id")
+			return
+		} else if errors.Is(err, database.ErrAlreadyExist) {
+			respondWithError(w, http.StatusConflict, "chirp has been rechirped again since")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
+}
+
 func cleanChirp(msg string) string {
 	words := strings.Fields(msg)
 	// PERF: refactor badWords from list to map
@@ -141,6 +342,20 @@ func cleanChirp(msg string) string {
 	return strings.Join(cleanedWords, " ")
 }
 
+// respondWithChirpError responds with the status matching an error from
+// reading a chirp: 404 if it doesn't exist, 410 if it was deleted and 500
+// otherwise.
+func respondWithChirpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrDeleted):
+		respondWithError(w, http.StatusGone, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	type errBody struct {
 		Error string
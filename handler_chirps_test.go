@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+func TestCreateChirpEntityOffsetsMatchCleanedBody(t *testing.T) {
+	cfg := newTestConfig(database.NewMemoryDB())
+	server := httptest.NewServer(cfg.handler())
+	t.Cleanup(server.Close)
+	bearer := signUp(t, server, "a@example.com")
+
+	// cleaning shortens the bad word and collapses the spaces before the
+	// entities
+	var chirp database.Chirp
+	do(t, server, http.MethodPost, "/api/chirps", bearer,
+		map[string]string{"body": "what  a   Kerfuffle #Go @a@example.com @nobody@example.com"}, &chirp, http.StatusCreated)
+	if chirp.Body != "what a **** #Go @a@example.com @nobody@example.com" {
+		t.Fatalf("body = %q", chirp.Body)
+	}
+	body := []rune(chirp.Body)
+	if len(chirp.Hashtags) != 1 || chirp.Hashtags[0].Tag != "go" || string(body[chirp.Hashtags[0].Start:chirp.Hashtags[0].End]) != "#Go" {
+		t.Errorf("hashtags = %+v, want #Go at its offset in the cleaned body", chirp.Hashtags)
+	}
+	if len(chirp.Mentions) != 1 || string(body[chirp.Mentions[0].Start:chirp.Mentions[0].End]) != "@a@example.com" {
+		t.Errorf("mentions = %+v, want only @a@example.com at its offset in the cleaned body", chirp.Mentions)
+	}
+}
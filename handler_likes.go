@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ammon134/chirpy/internal/auth"
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// handlerLikeChirp records that the user likes a chirp. Liking it again
+// changes nothing.
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleLike(w, r, cfg.db.LikeChirp)
+}
+
+// handlerUnlikeChirp takes back the user's like of a chirp, if there is
+// one.
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleLike(w, r, cfg.db.UnlikeChirp)
+}
+
+// handleLike runs a like or unlike for the user and responds with the chirp
+// and its new like count.
+func (cfg *apiConfig) handleLike(w http.ResponseWriter, r *http.Request, like func(ctx context.Context, id, userID int) (database.Chirp, error)) {
+	userID, err := auth.ParseForUserID(cfg.jwtSecret, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	chirp, err := like(r.Context(), chirpID, userID)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
+}
+
+// handlerGetChirpLikes lists who liked a chirp, most recent first.
+func (cfg *apiConfig) handlerGetChirpLikes(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+	_, err = cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+	cfg.respondWithLikePage(w, r, database.LikeQuery{ChirpID: chirpID})
+}
+
+// handlerGetUserLikes lists the chirps a user liked, most recent like
+// first. Deleted chirps are left out.
+func (cfg *apiConfig) handlerGetUserLikes(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	_, err = cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.respondWithLikePage(w, r, database.LikeQuery{UserID: userID})
+}
+
+// respondWithLikePage responds with the page of query's results selected
+// by the limit and cursor parameters, linking to the next one.
+func (cfg *apiConfig) respondWithLikePage(w http.ResponseWriter, r *http.Request, query database.LikeQuery) {
+	limit, err := likePager.prepare(r, &query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	likes, err := cfg.db.QueryLikes(r.Context(), query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, likePager.finish(w, r, query, likes, limit))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ammon134/chirpy/internal/auth"
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// handlerRechirp reposts a chirp for the user. Each user can rechirp a
+// chirp once.
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.ParseForUserID(cfg.jwtSecret, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	chirp, err := cfg.db.Rechirp(r.Context(), chirpID, userID)
+	if errors.Is(err, database.ErrAlreadyExist) {
+		respondWithError(w, http.StatusConflict, "chirp already rechirped")
+		return
+	}
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newChirpResponse(chirp))
+}
+
+// handlerUnrechirp takes back the user's rechirp of a chirp.
+func (cfg *apiConfig) handlerUnrechirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.ParseForUserID(cfg.jwtSecret, r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	err = cfg.db.Unrechirp(r.Context(), chirpID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "chirp not rechirped")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, http.StatusText(http.StatusOK))
+}
+
+// handlerGetRechirps lists the rechirps of a chirp, whose authors are the
+// users who rechirped it.
+func (cfg *apiConfig) handlerGetRechirps(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+	_, err = cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.RechirpOf = chirpID
+	cfg.respondWithChirpPage(w, r, query)
+}
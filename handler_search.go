@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+// handlerSearchChirps runs a full-text search over chirps. Results are
+// ranked by relevance and paginated like GET /api/chirps.
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := database.SearchQuery{Text: r.URL.Query().Get("q")}
+	authorID, err := strconv.Atoi(r.URL.Query().Get("author_id"))
+	if err == nil && authorID > 0 {
+		query.AuthorID = authorID
+	}
+
+	limit, err := searchPager.prepare(r, &query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.Weights == nil {
+		// a first page: rank it, and every page after it, with the weights
+		// as they are now
+		query.Weights, err = cfg.db.SearchWeights(r.Context(), query.Text)
+	}
+	var results []database.SearchResult
+	if err == nil {
+		results, err = cfg.db.SearchChirps(r.Context(), query)
+	}
+	if errors.Is(err, database.ErrEmptySearch) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	results = searchPager.finish(w, r, query, results, limit)
+
+	type result struct {
+		chirpResponse
+		Score float64 `json:"score"`
+	}
+	response := make([]result, 0, len(results))
+	for _, r := range results {
+		response = append(response, result{chirpResponse: newChirpResponse(r.Chirp), Score: r.Score})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
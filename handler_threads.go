@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// handlerGetThread responds with the conversation around a chirp: the chirps
+// it replies to, root first, and a page of the replies below it. Deleted
+// chirps among the ancestors show up as tombstones without a body; replies
+// to them are still listed. Ancestors stop below a chirp that has been
+// purged.
+func (cfg *apiConfig) handlerGetThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithChirpError(w, err)
+		return
+	}
+	ancestors, err := cfg.db.GetAncestors(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.DescendantsOf = chirpID
+	replies, ok := cfg.chirpPage(w, r, query)
+	if !ok {
+		return
+	}
+
+	type response struct {
+		Ancestors []chirpResponse `json:"ancestors"`
+		Chirp     chirpResponse   `json:"chirp"`
+		Replies   []chirpResponse `json:"replies"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Ancestors: newChirpResponses(ancestors),
+		Chirp:     newChirpResponse(chirp),
+		Replies:   newChirpResponses(replies),
+	})
+}
@@ -13,7 +13,13 @@ func (cfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.db.RevokeToken(bearerToken)
+	tokenID, expiresAt, err := auth.ParseRefreshToken(cfg.jwtSecret, bearerToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	err = cfg.db.RevokeToken(r.Context(), tokenID, expiresAt)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -29,7 +35,13 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	revoked, err := cfg.db.IsRevoked(bearerToken)
+	tokenID, _, err := auth.ParseRefreshToken(cfg.jwtSecret, bearerToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	revoked, err := cfg.db.IsRevoked(r.Context(), tokenID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -38,7 +38,7 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 	}
 	// then update createUser to save hash password
 	// then return user without password hash
-	user, err := cfg.db.CreateUser(params.Email, hash)
+	user, err := cfg.db.CreateUser(r.Context(), params.Email, hash)
 	if err != nil {
 		if errors.Is(err, database.ErrAlreadyExist) {
 			respondWithError(w, http.StatusConflict, "user already exist")
@@ -85,8 +85,12 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.UpdateUser(userID, params.Email, hashedPassword)
+	user, err := cfg.db.UpdateUser(r.Context(), userID, params.Email, hashedPassword)
 	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExist) {
+			respondWithError(w, http.StatusConflict, "email already in use")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "could not update user")
 		return
 	}
@@ -46,7 +46,7 @@ func (cfg *apiConfig) handlerWebhookUpgradeUser(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = cfg.db.UpgradeUser(params.Data.UserID)
+	err = cfg.db.UpgradeUser(r.Context(), params.Data.UserID)
 	if err != nil {
 		if errors.Is(err, database.ErrNotExist) {
 			respondWithError(w, http.StatusNotFound, "user does not exist")
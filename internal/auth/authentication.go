@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strconv"
@@ -36,8 +38,13 @@ func CheckPasswordHash(hashedPassword []byte, password string) error {
 }
 
 func CreateJWT(jwtSecret string, userID int, duration time.Duration, tt TokenType) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
 	currentTime := time.Now().UTC()
 	claims := jwt.RegisteredClaims{
+		ID:        tokenID,
 		IssuedAt:  jwt.NewNumericDate(currentTime),
 		ExpiresAt: jwt.NewNumericDate(currentTime.Add(duration)),
 		Issuer:    string(tt),
@@ -52,6 +59,42 @@ func CreateJWT(jwtSecret string, userID int, duration time.Duration, tt TokenTyp
 	return ss, nil
 }
 
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ParseRefreshToken validates a refresh token and returns the ID it should
+// be revoked under along with its expiry. Tokens issued before JWTs carried
+// an ID are identified by the token string itself.
+func ParseRefreshToken(jwtSecret, tokenStr string) (string, time.Time, error) {
+	token, err := jwt.ParseWithClaims(
+		tokenStr,
+		&jwt.RegisteredClaims{},
+		func(t *jwt.Token) (interface{}, error) { return []byte(jwtSecret), nil },
+	)
+	if err != nil {
+		return "", time.Time{}, errors.New("token is invalid or has expired")
+	}
+	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	if !ok || claims.Issuer != string(TokenTypeRefresh) {
+		return "", time.Time{}, errors.New("invalid issuer")
+	}
+	if claims.ExpiresAt == nil {
+		return "", time.Time{}, errors.New("token has no expiry")
+	}
+
+	tokenID := claims.ID
+	if tokenID == "" {
+		tokenID = tokenStr
+	}
+	return tokenID, claims.ExpiresAt.Time, nil
+}
+
 func RefreshJWT(jwtSecret, tokenStr string) (string, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenStr,
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotBackup is returned when restoring data that isn't a database
+// snapshot.
+var ErrNotBackup = errors.New("not a database backup")
+
+// backupFields must be in every snapshot. Anything else can be left out
+// and is filled in empty, but without these the data is more likely to be
+// some other JSON than a database.
+var backupFields = []string{"schema_version", "chirp_table", "user_table"}
+
+// Snapshot writes a consistent copy of the whole database to w, in the same
+// format as the database file. Only the encoding happens under the lock, so
+// a slow writer doesn't hold up other transactions.
+func (db *DB) Snapshot(ctx context.Context, w io.Writer) error {
+	var data []byte
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		data, err = json.Marshal(tx.dbs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ValidateBackup checks that data is a database snapshot this version of
+// chirpy can load, migrating it in memory if it was taken by an older one,
+// and returns it in the current format. Encrypted data must be opened
+// first; ValidateBackup returns ErrEncrypted for it.
+func ValidateBackup(data []byte) ([]byte, error) {
+	if isSealed(data) {
+		return nil, ErrEncrypted
+	}
+	doc := document{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotBackup, err)
+	}
+	for _, field := range backupFields {
+		raw, ok := doc[field]
+		if !ok || bytes.Equal(raw, []byte("null")) {
+			return nil, fmt.Errorf("%w: no %s", ErrNotBackup, field)
+		}
+	}
+
+	migrated, _, _, err := migrateDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	_, err = decodeSnapshot(migrated)
+	if err != nil {
+		return nil, err
+	}
+	return migrated, nil
+}
+
+// Restore replaces the database file at path with the backup read from r.
+// The current file is kept as the newest generation and any journal is
+// discarded, since its records belong to the database being replaced. An
+// encrypted backup, such as a previous generation of an encrypted database,
+// is opened with the keyring given by WithEncryption.
+func Restore(path string, r io.Reader, opts ...Option) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	cfg := newConfig(opts)
+	data, err = cfg.keys.open(data)
+	if err != nil {
+		return err
+	}
+	data, err = ValidateBackup(data)
+	if err != nil {
+		return err
+	}
+
+	f := cfg.snapshotFile(path)
+	return withExclusiveLock(path, func() error {
+		err := f.writeRaw(path, data, f.generations)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(journalPath(path))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
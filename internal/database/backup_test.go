@@ -0,0 +1,105 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreRefusesWhatIsNotABackup(t *testing.T) {
+	ctx := context.Background()
+	keys, err := ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := filepath.Join(t.TempDir(), "database.json")
+	db := openTestDB(t, EngineFile, encrypted, WithEncryption(keys))
+	db.CreateUser(ctx, "a@example.com", nil)
+	db.CreateUser(ctx, "b@example.com", nil)
+	sealed, err := os.ReadFile(generationPath(encrypted, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data string
+		want error
+	}{
+		{"empty object", `{}`, ErrNotBackup},
+		{"other JSON", `{"foo":1}`, ErrNotBackup},
+		{"null tables", `{"schema_version":5,"chirp_table":null,"user_table":null}`, ErrNotBackup},
+		{"encrypted generation", string(sealed), ErrEncrypted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "database.json")
+			db := openTestDB(t, EngineFile, path)
+			_, err := db.CreateUser(ctx, "kept@example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.ReplaceWithSnapshot(ctx, strings.NewReader(tc.data))
+			if !errors.Is(err, tc.want) {
+				t.Errorf("ReplaceWithSnapshot: got %v, want %v", err, tc.want)
+			}
+			if _, err := db.GetUserByEmail(ctx, "kept@example.com"); err != nil {
+				t.Errorf("user after a refused ReplaceWithSnapshot: %v", err)
+			}
+
+			db.Close()
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Restore(path, strings.NewReader(tc.data))
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Restore: got %v, want %v", err, tc.want)
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(before, after) {
+				t.Error("Restore changed the database file")
+			}
+		})
+	}
+}
+
+func TestRestoreOpensEncryptedBackupWithKeyring(t *testing.T) {
+	ctx := context.Background()
+	keys, err := ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "database.json")
+	db := openTestDB(t, EngineFile, path, WithEncryption(keys))
+	db.CreateUser(ctx, "a@example.com", nil)
+	db.CreateUser(ctx, "b@example.com", nil)
+	db.Close()
+	backup := filepath.Join(t.TempDir(), "backup.json")
+	copyFile(t, generationPath(path, 1), backup)
+
+	f, err := os.Open(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = Restore(path, f, WithEncryption(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDB(t, EngineFile, path, WithEncryption(keys))
+	if _, err := db.GetUserByEmail(ctx, "a@example.com"); err != nil {
+		t.Errorf("user from the restored generation: %v", err)
+	}
+	if _, err := db.GetUserByEmail(ctx, "b@example.com"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("user written after the restored generation: got %v, want ErrNotExist", err)
+	}
+}
@@ -1,99 +1,410 @@
 package database
 
-import "errors"
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
 
 type ChirpTable struct {
 	Chirps    map[int]Chirp `json:"chirps"`
-	NextIndex int           `json:"max_index"`
+	NextIndex int           `json:"next_index"`
 }
 
 type Chirp struct {
 	Body     string `json:"body"`
 	ID       int    `json:"id"`
 	AuthorID int    `json:"author_id"`
+	// chirps from before these were recorded have the time of the migration
+	// that added them
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// InReplyTo is the chirp this one replies to and RootID the chirp that
+	// started the conversation. Both are 0 for chirps that aren't replies.
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	RootID    int `json:"root_id,omitempty"`
+	// RechirpOf is set on a rechirp, which reposts another chirp and has no
+	// body of its own. QuoteOf is set on a chirp quoting another one.
+	RechirpOf int `json:"rechirp_of,omitempty"`
+	QuoteOf   int `json:"quote_of,omitempty"`
+	// Hashtags and Mentions are parsed from Body whenever it is written.
+	Hashtags []Hashtag `json:"hashtags"`
+	Mentions []Mention `json:"mentions"`
+	// Edited is set once the body has been changed; the previous versions
+	// are in DBStructure.ChirpHistory.
+	Edited bool `json:"edited"`
+	// DeletedAt and DeletedBy are set on a deleted chirp, which is kept as a
+	// tombstone until it is purged so it can still be restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy int        `json:"deleted_by,omitempty"`
+
+	// ChirpStats is worked out from the rest of the database when a chirp is
+	// read, so it is never stored with it.
+	ChirpStats `json:"-"`
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	// loadDB
-	dbs, err := db.loadDB()
-	if err != nil {
+// ChirpStats is what the rest of the database says about a chirp.
+type ChirpStats struct {
+	RechirpCount int `json:"rechirp_count"`
+	QuoteCount   int `json:"quote_count"`
+	LikeCount    int `json:"like_count"`
+	// OriginalDeleted is set on a rechirp or quote whose original has been
+	// deleted.
+	OriginalDeleted bool `json:"original_deleted,omitempty"`
+}
+
+// ErrDeleted is returned when reading a chirp that has been deleted but not
+// purged yet.
+var ErrDeleted = errors.New("has been deleted")
+
+// ErrRestoreWindowPassed is returned when restoring a chirp that was deleted
+// too long ago.
+var ErrRestoreWindowPassed = errors.New("restore window has passed")
+
+func (c Chirp) Deleted() bool {
+	return c.DeletedAt != nil
+}
+
+// NewChirp is what CreateChirp needs to know about a chirp being posted.
+type NewChirp struct {
+	Body     string
+	AuthorID int
+	// InReplyTo is the chirp being replied to and QuoteOf the chirp being
+	// quoted, if any. They must exist and not be deleted; a rechirp stands
+	// for the chirp it reposts.
+	InReplyTo int
+	QuoteOf   int
+}
+
+func (tx *Tx) CreateChirp(c NewChirp) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
 		return Chirp{}, err
 	}
 	// create Chirp, give it ID
+	now := time.Now().UTC()
 	chirp := Chirp{
-		ID:       dbs.ChirpTable.NextIndex,
-		Body:     body,
-		AuthorID: authorID,
-	}
-	dbs.ChirpTable.Chirps[dbs.ChirpTable.NextIndex] = chirp
-	dbs.ChirpTable.NextIndex++
-	// writeDB
-	err = db.writeDB(dbs)
-	if err != nil {
-		return Chirp{}, err
+		ID:        tx.dbs.ChirpTable.NextIndex,
+		Body:      c.Body,
+		AuthorID:  c.AuthorID,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
-
+	if c.InReplyTo != 0 {
+		parent, err := tx.original(c.InReplyTo)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("in_reply_to %d: %w", c.InReplyTo, err)
+		}
+		chirp.InReplyTo = parent.ID
+		chirp.RootID = parent.RootID
+		if chirp.RootID == 0 {
+			chirp.RootID = parent.ID
+		}
+	}
+	if c.QuoteOf != 0 {
+		quoted, err := tx.original(c.QuoteOf)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("quote_of %d: %w", c.QuoteOf, err)
+		}
+		chirp.QuoteOf = quoted.ID
+	}
+	tx.setEntities(&chirp)
+	tx.putChirp(chirp.ID, chirp, chirp.ID+1)
 	return chirp, nil
 }
 
-func (db *DB) GetChirps() ([]Chirp, error) {
-	// loadDB
-	dbs, err := db.loadDB()
-	if err != nil {
-		return nil, err
+type ChirpOrder string
+
+const (
+	OrderByID        ChirpOrder = "id"
+	OrderByCreatedAt ChirpOrder = "created_at"
+)
+
+// ChirpQuery selects chirps for QueryChirps. The zero value selects every
+// chirp, oldest ID first.
+type ChirpQuery struct {
+	// AuthorID restricts the result to one author; 0 selects every author.
+	AuthorID int
+	// Hashtag restricts the result to chirps using a hashtag, given without
+	// the #; "" doesn't restrict it.
+	Hashtag string
+	// MentionedUserID restricts the result to chirps mentioning a user; 0
+	// doesn't restrict it.
+	MentionedUserID int
+	// RechirpOf restricts the result to the rechirps of a chirp; 0 doesn't
+	// restrict it.
+	RechirpOf int
+	// DescendantsOf restricts the result to the replies to a chirp, the
+	// replies to those and so on; 0 doesn't restrict it.
+	DescendantsOf int
+	// Since and Until restrict the result to chirps created at or after
+	// Since and before Until. Zero times leave that end open.
+	Since time.Time
+	Until time.Time
+	// OrderBy defaults to OrderByID. Chirps created at the same time are
+	// ordered by ID.
+	OrderBy    ChirpOrder
+	Descending bool
+	// After continues a previous query from the chirp it ended with. Chirps
+	// inserted since then don't shift the result, they only show up if they
+	// sort after After.
+	After *ChirpCursor
+	// Limit caps the number of chirps returned; 0 returns all of them.
+	Limit int
+}
+
+// ChirpCursor is the position of a chirp in the results of a ChirpQuery.
+type ChirpCursor struct {
+	ID        int
+	CreatedAt time.Time
+}
+
+func (c Chirp) Cursor() ChirpCursor {
+	return ChirpCursor{ID: c.ID, CreatedAt: c.CreatedAt}
+}
+
+// compare orders chirp relative to the cursor in ascending order.
+func (c ChirpCursor) compare(chirp Chirp, orderBy ChirpOrder) int {
+	if orderBy == OrderByCreatedAt {
+		if n := chirp.CreatedAt.Compare(c.CreatedAt); n != 0 {
+			return n
+		}
 	}
+	return cmp.Compare(chirp.ID, c.ID)
+}
 
-	chirps := []Chirp{}
-	for _, chirp := range dbs.ChirpTable.Chirps {
+// QueryChirps returns the chirps that aren't deleted and match q, in the
+// order q asks for. Rechirps of deleted chirps are left out.
+func (tx *Tx) QueryChirps(q ChirpQuery) ([]Chirp, error) {
+	hashtag := NormalizeHashtag(q.Hashtag)
+	// start from the most selective index, then check the rest per chirp
+	ids := tx.dbs.idx.chirpIDs
+	switch {
+	case q.RechirpOf != 0:
+		ids = tx.dbs.idx.rechirpsOf[q.RechirpOf]
+	case q.DescendantsOf != 0:
+		ids = tx.dbs.idx.repliesByRoot[tx.rootOf(q.DescendantsOf)]
+	case hashtag != "":
+		ids = tx.dbs.idx.chirpsByHashtag[hashtag]
+	case q.MentionedUserID != 0:
+		ids = tx.dbs.idx.chirpsMentioning[q.MentionedUserID]
+	case q.AuthorID != 0:
+		ids = tx.dbs.idx.chirpsByAuthor[q.AuthorID]
+	}
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirp := tx.dbs.ChirpTable.Chirps[id]
+		if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+			continue
+		}
+		if hashtag != "" && !chirp.hasHashtag(hashtag) {
+			continue
+		}
+		if q.MentionedUserID != 0 && !chirp.mentions(q.MentionedUserID) {
+			continue
+		}
+		if q.RechirpOf != 0 && chirp.RechirpOf != q.RechirpOf {
+			continue
+		}
+		if chirp.RechirpOf != 0 && !tx.isLive(chirp.RechirpOf) {
+			continue
+		}
+		if q.DescendantsOf != 0 && !tx.descendsFrom(chirp, q.DescendantsOf) {
+			continue
+		}
+		if !q.Since.IsZero() && chirp.CreatedAt.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && !chirp.CreatedAt.Before(q.Until) {
+			continue
+		}
 		chirps = append(chirps, chirp)
 	}
-	return chirps, nil
-}
 
-func (db *DB) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
-	// loadDB
-	dbs, err := db.loadDB()
-	if err != nil {
-		return nil, err
+	switch q.OrderBy {
+	case "", OrderByID:
+	case OrderByCreatedAt:
+		// stable, so ties keep their ID order
+		slices.SortStableFunc(chirps, func(a, b Chirp) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+	default:
+		return nil, fmt.Errorf("database: unknown chirp order %q", q.OrderBy)
+	}
+	if q.Descending {
+		slices.Reverse(chirps)
 	}
 
-	chirps := []Chirp{}
-	for _, chirp := range dbs.ChirpTable.Chirps {
-		if authorID == -1 {
-			chirps = append(chirps, chirp)
-		} else if chirp.AuthorID == authorID {
-			chirps = append(chirps, chirp)
+	if q.After != nil {
+		start := len(chirps)
+		for i, chirp := range chirps {
+			n := q.After.compare(chirp, q.OrderBy)
+			if q.Descending && n < 0 || !q.Descending && n > 0 {
+				start = i
+				break
+			}
 		}
+		chirps = chirps[start:]
+	}
+	if q.Limit > 0 && len(chirps) > q.Limit {
+		chirps = chirps[:q.Limit]
 	}
 	return chirps, nil
 }
 
-func (db *DB) GetChirp(id int) (Chirp, error) {
-	// loadDB
-	dbs, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
+// GetChirp returns the chirp with the given ID, or ErrDeleted if it has been
+// deleted.
+func (tx *Tx) GetChirp(id int) (Chirp, error) {
+	chirp, ok := tx.dbs.ChirpTable.Chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotExist
+	}
+	if chirp.Deleted() {
+		return Chirp{}, ErrDeleted
 	}
+	return chirp, nil
+}
 
-	chirp, ok := dbs.ChirpTable.Chirps[id]
-	if !ok {
-		return Chirp{}, errors.New("chirp doesn't exist")
+// GetDeletedChirp returns the tombstone of a deleted chirp. It returns
+// ErrNotExist for chirps that are not deleted.
+func (tx *Tx) GetDeletedChirp(id int) (Chirp, error) {
+	chirp, ok := tx.dbs.ChirpTable.Chirps[id]
+	if !ok || !chirp.Deleted() {
+		return Chirp{}, ErrNotExist
 	}
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	// loadDB
-	dbs, err := db.loadDB()
-	if err != nil {
+// DeleteChirp marks a chirp as deleted by the user deletedBy. It stays
+// restorable until PurgeDeletedChirps removes it.
+func (tx *Tx) DeleteChirp(id int, deletedBy int) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
-
-	delete(dbs.ChirpTable.Chirps, id)
-
-	err = db.writeDB(dbs)
+	chirp, err := tx.GetChirp(id)
 	if err != nil {
 		return err
 	}
+	now := time.Now().UTC()
+	chirp.DeletedAt = &now
+	chirp.DeletedBy = deletedBy
+	tx.putChirp(id, chirp, tx.dbs.ChirpTable.NextIndex)
 	return nil
 }
+
+// RestoreChirp undoes the deletion of a chirp, as long as it was deleted
+// after deletedAfter.
+func (tx *Tx) RestoreChirp(id int, deletedAfter time.Time) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := tx.GetDeletedChirp(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.DeletedAt.Before(deletedAfter) {
+		return Chirp{}, ErrRestoreWindowPassed
+	}
+	if chirp.RechirpOf != 0 {
+		if _, ok := tx.rechirpBy(chirp.RechirpOf, chirp.AuthorID); ok {
+			// rechirped again since
+			return Chirp{}, ErrAlreadyExist
+		}
+	}
+	chirp.DeletedAt = nil
+	chirp.DeletedBy = 0
+	tx.putChirp(id, chirp, tx.dbs.ChirpTable.NextIndex)
+	return chirp, nil
+}
+
+// PurgeDeletedChirps permanently removes every chirp deleted before
+// deletedBefore, along with its history and likes, and returns how many
+// were removed.
+func (tx *Tx) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	purged := 0
+	for id, chirp := range tx.dbs.ChirpTable.Chirps {
+		if chirp.Deleted() && chirp.DeletedAt.Before(deletedBefore) {
+			tx.deleteChirp(id)
+			tx.putChirpHistory(id, nil)
+			tx.deleteLikes(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (db *DB) CreateChirp(ctx context.Context, c NewChirp) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.CreateChirp(c)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) QueryChirps(ctx context.Context, q ChirpQuery) ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		chirps, err = tx.QueryChirps(q)
+		tx.fillAllStats(chirps)
+		return err
+	})
+	return chirps, err
+}
+
+func (db *DB) GetChirp(ctx context.Context, id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.GetChirp(id)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) GetDeletedChirp(ctx context.Context, id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.GetDeletedChirp(id)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) DeleteChirp(ctx context.Context, id int, deletedBy int) error {
+	return db.Update(ctx, func(tx *Tx) error {
+		return tx.DeleteChirp(id, deletedBy)
+	})
+}
+
+func (db *DB) RestoreChirp(ctx context.Context, id int, deletedAfter time.Time) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.RestoreChirp(id, deletedAfter)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) PurgeDeletedChirps(ctx context.Context, deletedBefore time.Time) (int, error) {
+	var purged int
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		purged, err = tx.PurgeDeletedChirps(deletedBefore)
+		return err
+	})
+	return purged, err
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChirpStatsNotStored(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db := openTestDB(t, EngineFile, path)
+	user, _ := db.CreateUser(ctx, "a@example.com", nil)
+	chirp, _ := db.CreateChirp(ctx, NewChirp{Body: "liked", AuthorID: user.ID})
+	liked, err := db.LikeChirp(ctx, chirp.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if liked.LikeCount != 1 {
+		t.Errorf("LikeCount = %d, want 1", liked.LikeCount)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"rechirp_count", "quote_count", "like_count", "original_deleted"} {
+		if bytes.Contains(data, []byte(field)) {
+			t.Errorf("stored database holds %s", field)
+		}
+	}
+}
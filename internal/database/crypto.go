@@ -0,0 +1,152 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const encryptionAlgorithm = "aes-256-gcm"
+
+var (
+	ErrEncrypted  = errors.New("database file is encrypted but no key was configured")
+	ErrUnknownKey = errors.New("database file is encrypted with an unknown key")
+)
+
+// Key is an AES-256 key with the ID it is recorded under in encrypted files.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring holds the keys used for encryption at rest. The first key
+// encrypts everything written; the others are only used to decrypt files
+// written before a rotation, which are re-encrypted with the first key the
+// next time they are written.
+type Keyring struct {
+	keys []Key
+}
+
+// ParseKeyring parses keys written as id:base64-secret, separated by commas
+// or newlines, current key first. Blank lines and lines starting with # are
+// ignored so the same format works for key files.
+func ParseKeyring(spec string) (*Keyring, error) {
+	keyring := &Keyring{}
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		id, encoded, found := strings.Cut(field, ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("database: key %q is not in id:base64 form", field)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("database: key %q: %w", id, err)
+		}
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("database: key %q must be 32 bytes, got %d", id, len(secret))
+		}
+		keyring.keys = append(keyring.keys, Key{ID: id, Secret: secret})
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("database: no encryption keys given")
+	}
+	return keyring, nil
+}
+
+func (k *Keyring) current() Key {
+	return k.keys[0]
+}
+
+func (k *Keyring) find(id string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// envelope is how encrypted data is stored. The encryption field comes
+// first so isSealed can recognise an envelope without decoding it.
+type envelope struct {
+	Encryption string `json:"encryption"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var envelopePrefix = []byte(`{"encryption":`)
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, envelopePrefix)
+}
+
+func newGCM(key Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with the current key. A nil keyring leaves the
+// data as it is. The key ID is authenticated along with the data.
+func (k *Keyring) seal(plaintext []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	key := k.current()
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{
+		Encryption: encryptionAlgorithm,
+		KeyID:      key.ID,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(key.ID)),
+	})
+}
+
+// open decrypts data written by seal. Data that isn't sealed is returned as
+// it is, which is what lets plaintext files be read and then encrypted.
+func (k *Keyring) open(data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrEncrypted
+	}
+	env := envelope{}
+	err := json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, err
+	}
+	if env.Encryption != encryptionAlgorithm {
+		return nil, fmt.Errorf("database: unsupported encryption %q", env.Encryption)
+	}
+	key, ok := k.find(env.KeyID)
+	if !ok {
+		return nil, fmt.Errorf("database: key %q: %w", env.KeyID, ErrUnknownKey)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, env.Nonce, env.Ciphertext, []byte(key.ID))
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptionCoversEveryPlaintextCopy(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db := openTestDB(t, EngineJournal, path)
+	_, err := db.CreateUser(ctx, "secret@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// copy the files while the journal still holds the record, as after a
+	// crash, plus a backup as Migrate leaves behind
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "database.json")
+	copyFile(t, path, plain)
+	copyFile(t, journalPath(path), journalPath(plain))
+	err = os.WriteFile(backupPath(plain, 3), []byte(`{"UserTable":{"Users":{"1":{"email":"secret@example.com"}}}}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDB(t, EngineJournal, plain, WithEncryption(keys))
+	user, err := db.GetUserByEmail(ctx, "secret@example.com")
+	if err != nil {
+		t.Fatalf("user from the plaintext journal: %v", err)
+	}
+	if string(user.HashedPassword) != "hash" {
+		t.Errorf("user = %+v", user)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret@example.com")) {
+			t.Errorf("%s still holds plaintext", entry.Name())
+		}
+	}
+}
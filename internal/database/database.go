@@ -1,90 +1,208 @@
 package database
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"os"
 	"sync"
 	"time"
 )
 
 type DB struct {
-	mux  *sync.RWMutex
-	path string
+	mux    *sync.RWMutex
+	engine engine
+	// lock coordinates with other processes using the same files. The file
+	// engine takes it per transaction; the journal engine keeps its state in
+	// memory, so it holds the lock exclusively for as long as the DB is open.
+	lock      *fileLock
+	lockPerTx bool
+	// changes holds recently committed transactions for followers.
+	changes *changeLog
 }
 
 type DBStructure struct {
-	RevokedTokens map[string]time.Time
-	ChirpTable    ChirpTable
-	UserTable     UserTable
+	SchemaVersion int `json:"schema_version"`
+	// Seq is the sequence number of the last committed transaction.
+	Seq           uint64                  `json:"seq"`
+	RevokedTokens map[string]RevokedToken `json:"revoked_tokens"`
+	ChirpTable    ChirpTable              `json:"chirp_table"`
+	UserTable     UserTable               `json:"user_table"`
+	// ChirpHistory holds the previous versions of edited chirps, keyed by
+	// chirp ID and oldest first.
+	ChirpHistory map[int][]ChirpRevision `json:"chirp_history"`
+	// Likes holds when each user liked a chirp, keyed by chirp ID and then
+	// user ID.
+	Likes map[int]map[int]time.Time `json:"likes"`
+
+	idx *indexes
 }
 
-var ErrNotExist = errors.New("does not exist")
+// DefaultGenerations is the number of previous versions of the database
+// file kept next to it as path.1, path.2, ...
+const DefaultGenerations = 3
 
-func NewDB(path string) (*DB, error) {
-	// ensure db exists
-	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+// DefaultCompactEvery is the number of journal records after which the
+// journal engine folds the log into a new snapshot.
+const DefaultCompactEvery = 1000
+
+type EngineType string
+
+const (
+	// EngineFile rewrites the whole database file on every change.
+	EngineFile EngineType = "file"
+	// EngineJournal appends changes to a write-ahead log next to a
+	// periodically compacted snapshot.
+	EngineJournal EngineType = "journal"
+	// EngineMemory never touches the disk; the path is ignored.
+	EngineMemory EngineType = "memory"
+)
+
+type config struct {
+	engine       EngineType
+	generations  int
+	compactEvery int
+	keys         *Keyring
+	changeBuffer int
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		engine:       EngineFile,
+		generations:  DefaultGenerations,
+		compactEvery: DefaultCompactEvery,
+		changeBuffer: DefaultChangeBuffer,
 	}
-	err := db.ensureDB()
-	return db, err
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
 }
 
-func (db *DB) ensureDB() error {
-	_, err := os.Stat(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return db.createDB()
+func (c config) snapshotFile(path string) *snapshotFile {
+	return &snapshotFile{
+		path:        path,
+		generations: c.generations,
+		keys:        c.keys,
 	}
-	return err
 }
 
-func (db *DB) createDB() error {
-	dbs := DBStructure{
-		ChirpTable: ChirpTable{
-			Chirps:    map[int]Chirp{},
-			NextIndex: 1,
-		},
-		UserTable: UserTable{
-			Users:     map[int]User{},
-			NextIndex: 1,
-		},
-		RevokedTokens: map[string]time.Time{},
+type Option func(*config)
+
+// WithEngine selects the storage engine. The empty string selects EngineFile.
+func WithEngine(e EngineType) Option {
+	return func(c *config) {
+		if e != "" {
+			c.engine = e
+		}
 	}
-	return db.writeDB(dbs)
 }
 
-func (db *DB) loadDB() (DBStructure, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
+// WithGenerations sets how many previous snapshots are kept on disk.
+func WithGenerations(n int) Option {
+	return func(c *config) { c.generations = n }
+}
+
+// WithCompactEvery sets how many journal records trigger a compaction.
+func WithCompactEvery(n int) Option {
+	return func(c *config) { c.compactEvery = n }
+}
+
+// WithChangeBuffer sets how many committed transactions are kept in memory
+// for followers to catch up from.
+func WithChangeBuffer(n int) Option {
+	return func(c *config) { c.changeBuffer = n }
+}
+
+// WithEncryption encrypts everything written to disk with the keyring's
+// current key. A nil keyring leaves encryption off. Existing plaintext files
+// are encrypted when the database is opened.
+func WithEncryption(keys *Keyring) Option {
+	return func(c *config) { c.keys = keys }
+}
 
-	dbs := DBStructure{}
-	file, err := os.ReadFile(db.path)
+var ErrNotExist = errors.New("does not exist")
+
+func NewDB(path string, opts ...Option) (*DB, error) {
+	cfg := newConfig(opts)
+	db := &DB{
+		mux: &sync.RWMutex{},
+	}
+
+	switch cfg.engine {
+	case EngineFile, EngineJournal:
+	case EngineMemory:
+		db.engine = &memEngine{dbs: newDBStructure()}
+		db.changes = newChangeLog(cfg.changeBuffer, 0)
+		return db, nil
+	default:
+		return nil, fmt.Errorf("database: unknown engine %q", cfg.engine)
+	}
+
+	// opening may create, migrate or recover the file, so it needs the
+	// lock to itself
+	lock, err := openFileLock(path)
 	if err != nil {
-		return dbs, err
+		return nil, err
+	}
+	err = lock.tryLockExclusive()
+	if err != nil {
+		lock.close()
+		return nil, fmt.Errorf("database: opening %s: %w", path, err)
 	}
 
-	err = json.Unmarshal(file, &dbs)
+	if cfg.engine == EngineFile {
+		db.engine, err = openFileEngine(cfg.snapshotFile(path))
+		if err == nil {
+			err = lock.unlock()
+		}
+		db.lockPerTx = true
+	} else {
+		db.engine, err = openJournalEngine(cfg.snapshotFile(path), cfg.compactEvery)
+	}
 	if err != nil {
-		fmt.Println("error here")
-		return dbs, err
+		lock.close()
+		return nil, err
 	}
+	db.lock = lock
 
-	return dbs, nil
+	seq, err := db.Seq(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	db.changes = newChangeLog(cfg.changeBuffer, seq)
+	return db, nil
 }
 
-func (db *DB) writeDB(dbs DBStructure) error {
+// Close releases the storage engine. The DB must not be used afterwards.
+func (db *DB) Close() error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
-
-	file, err := json.Marshal(dbs)
-	if err != nil {
-		return err
+	err := db.engine.close()
+	if db.lock != nil {
+		// closing the file releases any lock still held on it
+		if closeErr := db.lock.close(); err == nil {
+			err = closeErr
+		}
 	}
-	err = os.WriteFile(db.path, file, 0666)
-	if err != nil {
-		return err
+	return err
+}
+
+func newDBStructure() *DBStructure {
+	dbs := &DBStructure{
+		SchemaVersion: CurrentSchemaVersion,
+		ChirpTable: ChirpTable{
+			Chirps:    map[int]Chirp{},
+			NextIndex: 1,
+		},
+		UserTable: UserTable{
+			Users:     map[int]User{},
+			NextIndex: 1,
+		},
+		RevokedTokens: map[string]RevokedToken{},
+		ChirpHistory:  map[int][]ChirpRevision{},
+		Likes:         map[int]map[int]time.Time{},
 	}
-	return nil
+	dbs.buildIndexes()
+	return dbs
 }
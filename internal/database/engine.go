@@ -0,0 +1,16 @@
+package database
+
+// engine persists a DBStructure. Engines are not safe for concurrent use;
+// DB serializes access with its own lock.
+type engine interface {
+	// load returns the current contents of the database. The returned
+	// structure may be shared with the engine, so callers must only modify
+	// it inside a transaction that is either committed or rolled back.
+	load() (*DBStructure, error)
+	// commit persists a transaction. dbs is the state after the transaction
+	// and recs are the mutations it performed, in order.
+	commit(dbs *DBStructure, recs []record) error
+	// replace swaps the whole database for dbs and persists it.
+	replace(dbs *DBStructure) error
+	close() error
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Hashtag is a #tag in a chirp body. Start and End are rune offsets into the
+// body, End exclusive, and cover the leading #. Tag is lower-cased and
+// without the #.
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Mention is an @email in a chirp body that names an existing user. Start
+// and End are rune offsets into the body, End exclusive, and cover the
+// leading @.
+type Mention struct {
+	UserID int `json:"user_id"`
+	Start  int `json:"start"`
+	End    int `json:"end"`
+}
+
+// NormalizeHashtag returns the form tags are stored and looked up in.
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isEmailRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._%+-@", r)
+}
+
+// parseEntities finds the hashtags and mentions in body. userByEmail
+// resolves a mentioned email to a user ID; mentions it doesn't know are
+// left out. A # or @ only starts an entity at the beginning of the body or
+// after a character that can't be part of a word.
+func parseEntities(body string, userByEmail func(email string) (int, bool)) ([]Hashtag, []Mention) {
+	hashtags := []Hashtag{}
+	mentions := []Mention{}
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r != '#' && r != '@' || i > 0 && isEmailRune(runes[i-1]) {
+			continue
+		}
+		end := i + 1
+		if r == '#' {
+			for end < len(runes) && isTagRune(runes[end]) {
+				end++
+			}
+			if end > i+1 {
+				hashtags = append(hashtags, Hashtag{Tag: NormalizeHashtag(string(runes[i+1 : end])), Start: i, End: end})
+			}
+		} else {
+			for end < len(runes) && isEmailRune(runes[end]) {
+				end++
+			}
+			// "@a@b.c." ends a sentence, the dot isn't part of the email
+			for end > i+1 && strings.ContainsRune(".-", runes[end-1]) {
+				end--
+			}
+			if id, ok := userByEmail(string(runes[i+1 : end])); ok {
+				mentions = append(mentions, Mention{UserID: id, Start: i, End: end})
+			}
+		}
+		i = end - 1
+	}
+	return hashtags, mentions
+}
+
+// setEntities fills in the hashtags and mentions of chirp from its body.
+func (tx *Tx) setEntities(chirp *Chirp) {
+	chirp.Hashtags, chirp.Mentions = parseEntities(chirp.Body, func(email string) (int, bool) {
+		user, err := tx.GetUserByEmail(email)
+		return user.ID, err == nil
+	})
+}
+
+func (c Chirp) hasHashtag(tag string) bool {
+	for _, h := range c.Hashtags {
+		if h.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Chirp) mentions(userID int) bool {
+	for _, m := range c.Mentions {
+		if m.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// HashtagUse is a single use of a hashtag by a chirp.
+type HashtagUse struct {
+	Tag      string
+	ChirpID  int
+	AuthorID int
+	At       time.Time
+}
+
+// HashtagUses returns every use of a hashtag by a chirp that isn't deleted
+// and was created at or after since, in no particular order. A chirp using
+// a tag twice counts once.
+func (tx *Tx) HashtagUses(since time.Time) ([]HashtagUse, error) {
+	uses := []HashtagUse{}
+	for tag, ids := range tx.dbs.idx.chirpsByHashtag {
+		for _, id := range ids {
+			chirp := tx.dbs.ChirpTable.Chirps[id]
+			if chirp.CreatedAt.Before(since) {
+				continue
+			}
+			uses = append(uses, HashtagUse{Tag: tag, ChirpID: id, AuthorID: chirp.AuthorID, At: chirp.CreatedAt})
+		}
+	}
+	return uses, nil
+}
+
+func (db *DB) HashtagUses(ctx context.Context, since time.Time) ([]HashtagUse, error) {
+	var uses []HashtagUse
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		uses, err = tx.HashtagUses(since)
+		return err
+	})
+	return uses, err
+}
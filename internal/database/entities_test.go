@@ -0,0 +1,104 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEntities(t *testing.T) {
+	users := map[string]int{"a@example.com": 1, "b@example.com": 2}
+	lookup := func(email string) (int, bool) {
+		id, ok := users[email]
+		return id, ok
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		wantHashtags []Hashtag
+		wantMentions []Mention
+	}{
+		{
+			name:         "offsets count runes, not bytes",
+			body:         "café #naïve 🐦 @a@example.com",
+			wantHashtags: []Hashtag{{Tag: "naïve", Start: 5, End: 11}},
+			wantMentions: []Mention{{UserID: 1, Start: 14, End: 28}},
+		},
+		{
+			name:         "tags are lower-cased",
+			body:         "#GoLang",
+			wantHashtags: []Hashtag{{Tag: "golang", Start: 0, End: 7}},
+		},
+		{
+			name:         "trailing punctuation ends a hashtag",
+			body:         "#go! #chirpy. #x_y,",
+			wantHashtags: []Hashtag{{Tag: "go", Start: 0, End: 3}, {Tag: "chirpy", Start: 5, End: 12}, {Tag: "x_y", Start: 14, End: 18}},
+		},
+		{
+			name:         "trailing dots and dashes are not part of an email",
+			body:         "ask @a@example.com. or @b@example.com-",
+			wantMentions: []Mention{{UserID: 1, Start: 4, End: 18}, {UserID: 2, Start: 23, End: 37}},
+		},
+		{
+			name:         "punctuation around a mention",
+			body:         "(@a@example.com)",
+			wantMentions: []Mention{{UserID: 1, Start: 1, End: 15}},
+		},
+		{
+			name: "# and @ alone",
+			body: "# @ #",
+		},
+		{
+			name:         "a second # starts the tag",
+			body:         "##go",
+			wantHashtags: []Hashtag{{Tag: "go", Start: 1, End: 4}},
+		},
+		{
+			name: "no entities inside words",
+			body: "a#b c@a@example.com",
+		},
+		{
+			name:         "mentions of unknown users are left out",
+			body:         "@unknown@x.com #tag @a@example.com",
+			wantHashtags: []Hashtag{{Tag: "tag", Start: 15, End: 19}},
+			wantMentions: []Mention{{UserID: 1, Start: 20, End: 34}},
+		},
+		{
+			name: "a known email inside an unknown one is not a mention",
+			body: "@x@a@example.com",
+		},
+		{
+			name: "empty body",
+			body: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashtags, mentions := parseEntities(tt.body, lookup)
+			if tt.wantHashtags == nil {
+				tt.wantHashtags = []Hashtag{}
+			}
+			if tt.wantMentions == nil {
+				tt.wantMentions = []Mention{}
+			}
+			if !reflect.DeepEqual(hashtags, tt.wantHashtags) {
+				t.Errorf("hashtags = %+v, want %+v", hashtags, tt.wantHashtags)
+			}
+			if !reflect.DeepEqual(mentions, tt.wantMentions) {
+				t.Errorf("mentions = %+v, want %+v", mentions, tt.wantMentions)
+			}
+			// offsets must slice the entity out of the body
+			runes := []rune(tt.body)
+			for _, h := range hashtags {
+				if got := NormalizeHashtag(string(runes[h.Start:h.End])); got != h.Tag {
+					t.Errorf("body[%d:%d] = %q, want #%s", h.Start, h.End, got, h.Tag)
+				}
+			}
+			for _, m := range mentions {
+				if runes[m.Start] != '@' {
+					t.Errorf("body[%d:%d] = %q, want a mention", m.Start, m.End, string(runes[m.Start:m.End]))
+				}
+			}
+		})
+	}
+}
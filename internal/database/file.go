@@ -0,0 +1,390 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// snapshotFile is the database file at path together with its older
+// generations. With a keyring set everything it writes is encrypted.
+type snapshotFile struct {
+	path        string
+	generations int
+	keys        *Keyring
+}
+
+// fileEngine keeps the whole database in a single JSON file that is
+// rewritten on every commit. The decoded contents are cached between
+// transactions and only re-read when the file changes underneath us.
+type fileEngine struct {
+	file *snapshotFile
+
+	// mux guards the cache: concurrent read transactions may all notice
+	// the file changed and try to reload it
+	mux  sync.Mutex
+	dbs  *DBStructure
+	info os.FileInfo
+}
+
+// ErrModifiedExternally is returned when a commit would overwrite changes
+// made to the database file by something other than this DB.
+var ErrModifiedExternally = errors.New("database file was modified externally")
+
+func openFileEngine(file *snapshotFile) (*fileEngine, error) {
+	// the file engine knows nothing about the journal, so opening a database
+	// that still has unapplied journal records would silently lose them
+	info, err := os.Stat(journalPath(file.path))
+	if err == nil && info.Size() > 0 {
+		return nil, fmt.Errorf("database: %s has an unapplied journal, open it with the %s engine first", file.path, EngineJournal)
+	}
+
+	e := &fileEngine{file: file}
+	dbs, err := file.ensure()
+	if err != nil {
+		return nil, err
+	}
+	e.info, err = os.Stat(file.path)
+	if err != nil {
+		return nil, err
+	}
+	e.dbs = dbs
+	return e, nil
+}
+
+// changed reports whether the file on disk is no longer the one the cache
+// was filled from. Edits that keep the same inode, size and modification
+// time are not detected.
+func (e *fileEngine) changed() (bool, os.FileInfo, error) {
+	info, err := os.Stat(e.file.path)
+	if err != nil {
+		return false, nil, err
+	}
+	same := os.SameFile(info, e.info) &&
+		info.Size() == e.info.Size() &&
+		info.ModTime().Equal(e.info.ModTime())
+	return !same, info, nil
+}
+
+func (e *fileEngine) load() (*DBStructure, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	changed, info, err := e.changed()
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return e.dbs, nil
+	}
+
+	log.Printf("database: %s changed on disk, reloading", e.file.path)
+	dbs, err := e.file.read()
+	if err != nil {
+		return nil, err
+	}
+	e.dbs = dbs
+	e.info = info
+	return e.dbs, nil
+}
+
+func (e *fileEngine) commit(dbs *DBStructure, recs []record) error {
+	// load checked the file at the start of the transaction; check again
+	// so a hand edit made since then is not clobbered
+	changed, _, err := e.changed()
+	if err != nil {
+		return err
+	}
+	if changed {
+		return fmt.Errorf("database: %s: %w", e.file.path, ErrModifiedExternally)
+	}
+
+	err = e.file.write(dbs)
+	if err != nil {
+		return err
+	}
+	e.info, err = os.Stat(e.file.path)
+	return err
+}
+
+func (e *fileEngine) replace(dbs *DBStructure) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	err := e.file.write(dbs)
+	if err != nil {
+		return err
+	}
+	e.dbs = dbs
+	e.info, err = os.Stat(e.file.path)
+	return err
+}
+
+func (e *fileEngine) close() error {
+	return nil
+}
+
+// readRaw returns the decrypted contents of path.
+func (f *snapshotFile) readRaw(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.keys.open(data)
+}
+
+// writeRaw encrypts data if needed and atomically writes it to path,
+// keeping keep generations of the previous contents.
+func (f *snapshotFile) writeRaw(path string, data []byte, keep int) error {
+	sealed, err := f.keys.seal(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, sealed, keep)
+}
+
+// ensure makes sure the file holds a decodable database at
+// CurrentSchemaVersion, creating an empty one, migrating an older one or
+// recovering from an older generation as needed, and returns its contents.
+func (f *snapshotFile) ensure() (*DBStructure, error) {
+	raw, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		dbs := newDBStructure()
+		return dbs, f.write(dbs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.keys.open(raw)
+	if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrUnknownKey) {
+		// a missing key is a configuration problem, not corruption
+		return nil, err
+	}
+	var dbs *DBStructure
+	if err == nil {
+		dbs, err = decodeSnapshot(data)
+	}
+	if errors.Is(err, ErrSchemaOutdated) {
+		data, _, err = f.migrate(data, false)
+		if err != nil {
+			return nil, err
+		}
+		dbs, err = decodeSnapshot(data)
+	}
+	if errors.Is(err, ErrSchemaTooNew) {
+		return nil, err
+	}
+	if err != nil {
+		return f.recover(err)
+	}
+
+	if f.keys != nil {
+		return dbs, f.encryptPlaintext()
+	}
+	return dbs, nil
+}
+
+// encryptPlaintext encrypts the copies of the database written before
+// encryption was turned on: the file itself, its generations and the
+// backups taken before migrations, which all hold the same data.
+func (f *snapshotFile) encryptPlaintext() error {
+	paths := []string{f.path}
+	for i := 1; i <= f.generations; i++ {
+		paths = append(paths, generationPath(f.path, i))
+	}
+	backups, err := migrationBackups(f.path)
+	if err != nil {
+		return err
+	}
+	paths = append(paths, backups...)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if isSealed(data) {
+			continue
+		}
+		log.Printf("database: encrypting %s with key %q", path, f.keys.current().ID)
+		err = f.writeRaw(path, data, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrationBackups returns the backups Migrate left next to path.
+func migrationBackups(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + ".v"
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".bak") && entry.Type().IsRegular() {
+			backups = append(backups, filepath.Join(filepath.Dir(path), name))
+		}
+	}
+	return backups, nil
+}
+
+// recover replaces a corrupt database file with the newest generation that
+// still decodes.
+func (f *snapshotFile) recover(cause error) (*DBStructure, error) {
+	for i := 1; i <= f.generations; i++ {
+		genPath := generationPath(f.path, i)
+		data, err := f.readRaw(genPath)
+		if err != nil {
+			continue
+		}
+		// generations may predate the last migration
+		data, _, _, err = migrateDocument(data)
+		if err != nil {
+			continue
+		}
+		dbs, err := decodeSnapshot(data)
+		if err != nil {
+			continue
+		}
+		log.Printf("database: %s is corrupt (%v), recovering from %s", f.path, cause, genPath)
+		// don't rotate here, that would push the corrupt file into the
+		// generations we just recovered from
+		return dbs, f.writeRaw(f.path, data, 0)
+	}
+	return nil, fmt.Errorf("database: %s is corrupt and no valid generation was found: %w", f.path, cause)
+}
+
+func (f *snapshotFile) read() (*DBStructure, error) {
+	data, err := f.readRaw(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(data)
+}
+
+func (f *snapshotFile) write(dbs *DBStructure) error {
+	data, err := json.Marshal(dbs)
+	if err != nil {
+		return err
+	}
+	return f.writeRaw(f.path, data, f.generations)
+}
+
+func decodeSnapshot(data []byte) (*DBStructure, error) {
+	dbs := newDBStructure()
+	dbs.SchemaVersion = 0
+	err := json.Unmarshal(data, dbs)
+	if err != nil {
+		return nil, err
+	}
+	if dbs.SchemaVersion < CurrentSchemaVersion {
+		return nil, fmt.Errorf("database: version %d: %w", dbs.SchemaVersion, ErrSchemaOutdated)
+	}
+	if dbs.SchemaVersion > CurrentSchemaVersion {
+		return nil, fmt.Errorf("database: version %d: %w", dbs.SchemaVersion, ErrSchemaTooNew)
+	}
+	dbs.buildIndexes()
+	return dbs, nil
+}
+
+// generationPath returns the path of the nth previous generation of the
+// database file, e.g. database.json.1 for the most recent one.
+func generationPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// writeFileAtomic replaces path with data so that a crash at any point
+// leaves either the old or the new contents on disk, never a mix. The
+// previous contents are kept as generation 1, shifting older generations up
+// and dropping anything past keep.
+func writeFileAtomic(path string, data []byte, keep int) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmpPath, 0666)
+	if err != nil {
+		return err
+	}
+
+	err = rotateGenerations(path, keep)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateGenerations shifts path.1..path.(keep-1) up by one and links the
+// current file as path.1. The current file itself is left in place.
+func rotateGenerations(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	err := os.Remove(generationPath(path, keep))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := keep - 1; i >= 1; i-- {
+		err = os.Rename(generationPath(path, i), generationPath(path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err = os.Link(path, generationPath(path, 1))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		// filesystems without hard links get a plain copy instead
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+		return os.WriteFile(generationPath(path, 1), data, 0666)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// not every platform supports fsync on directories; the rename has
+	// already happened, so treat this as best effort
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Codes identifying the kinds of problem Check reports.
+const (
+	ProblemKeyMismatch     = "key_mismatch"
+	ProblemNextIndex       = "next_index_too_low"
+	ProblemDuplicateEmail  = "duplicate_email"
+	ProblemOrphanedChirp   = "orphaned_chirp"
+	ProblemBadReference    = "bad_reference"
+	ProblemOrphanedHistory = "orphaned_history"
+	ProblemOrphanedLike    = "orphaned_like"
+)
+
+// Problem is a single broken invariant found by Check.
+type Problem struct {
+	Code     string `json:"code"`
+	Table    string `json:"table"`
+	Key      int    `json:"key,omitempty"`
+	Message  string `json:"message"`
+	Repaired bool   `json:"repaired"`
+}
+
+// CheckReport lists every problem Check found, in a stable order.
+type CheckReport struct {
+	Problems []Problem `json:"problems"`
+}
+
+// Unrepaired returns how many problems are still present.
+func (r CheckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Check validates the invariants the rest of the package relies on: map
+// keys match the IDs stored in the rows, each table's NextIndex is above
+// every ID in it, emails are unique, every chirp's author exists, every
+// chirp refers only to earlier chirps that fit (see referenceProblem), every
+// edit history belongs to a chirp and every like to a chirp and a user.
+// With repair set it fixes what it safely can, in a single transaction: rows
+// are re-keyed under their own ID, NextIndex is raised and orphaned chirps,
+// chirps with bad references, histories and likes are deleted. Duplicate
+// emails are only reported, since picking which account to keep needs a
+// human.
+func (db *DB) Check(ctx context.Context, repair bool) (CheckReport, error) {
+	var report CheckReport
+	check := func(tx *Tx) error {
+		report = tx.check(repair)
+		return nil
+	}
+	var err error
+	if repair {
+		err = db.Update(ctx, check)
+	} else {
+		err = db.View(ctx, check)
+	}
+	return report, err
+}
+
+func (tx *Tx) check(repair bool) CheckReport {
+	report := CheckReport{Problems: []Problem{}}
+	add := func(p Problem) { report.Problems = append(report.Problems, p) }
+
+	users := tx.dbs.UserTable.Users
+	for _, key := range sortedKeys(users) {
+		user := users[key]
+		if user.ID == key {
+			continue
+		}
+		p := Problem{
+			Code:    ProblemKeyMismatch,
+			Table:   tableUsers,
+			Key:     key,
+			Message: fmt.Sprintf("user %d is stored under key %d", user.ID, key),
+		}
+		if _, taken := users[user.ID]; repair && !taken {
+			tx.deleteUser(key)
+			tx.putUser(user.ID, user, tx.dbs.UserTable.NextIndex)
+			p.Repaired = true
+		}
+		add(p)
+	}
+
+	chirps := tx.dbs.ChirpTable.Chirps
+	for _, key := range sortedKeys(chirps) {
+		chirp := chirps[key]
+		if chirp.ID == key {
+			continue
+		}
+		p := Problem{
+			Code:    ProblemKeyMismatch,
+			Table:   tableChirps,
+			Key:     key,
+			Message: fmt.Sprintf("chirp %d is stored under key %d", chirp.ID, key),
+		}
+		if _, taken := chirps[chirp.ID]; repair && !taken {
+			tx.deleteChirp(key)
+			tx.putChirp(chirp.ID, chirp, tx.dbs.ChirpTable.NextIndex)
+			p.Repaired = true
+		}
+		add(p)
+	}
+
+	// chirps refer to their author by the ID stored in the user row, which
+	// is what ends up in the author's JWTs, not by map key
+	authors := map[int]bool{}
+	for _, user := range users {
+		authors[user.ID] = true
+	}
+	for _, key := range sortedKeys(chirps) {
+		chirp := chirps[key]
+		if authors[chirp.AuthorID] {
+			continue
+		}
+		p := Problem{
+			Code:    ProblemOrphanedChirp,
+			Table:   tableChirps,
+			Key:     key,
+			Message: fmt.Sprintf("chirp %d has unknown author %d", chirp.ID, chirp.AuthorID),
+		}
+		if repair {
+			tx.deleteChirp(key)
+			p.Repaired = true
+		}
+		add(p)
+	}
+
+	for _, key := range sortedKeys(chirps) {
+		problem := tx.referenceProblem(chirps[key])
+		if problem == "" {
+			continue
+		}
+		p := Problem{
+			Code:    ProblemBadReference,
+			Table:   tableChirps,
+			Key:     key,
+			Message: problem,
+		}
+		if repair {
+			tx.deleteChirp(key)
+			p.Repaired = true
+		}
+		add(p)
+	}
+
+	// runs after orphaned chirps were deleted, so their history goes too
+	for _, key := range sortedKeys(tx.dbs.ChirpHistory) {
+		if _, ok := chirps[key]; ok {
+			continue
+		}
+		p := Problem{
+			Code:    ProblemOrphanedHistory,
+			Table:   tableChirps,
+			Key:     key,
+			Message: fmt.Sprintf("edit history for missing chirp %d", key),
+		}
+		if repair {
+			tx.putChirpHistory(key, nil)
+			p.Repaired = true
+		}
+		add(p)
+	}
+
+	// likes refer to users by ID, like chirps do
+	for _, chirpID := range sortedKeys(tx.dbs.Likes) {
+		_, chirpExists := chirps[chirpID]
+		for _, userID := range sortedKeys(tx.dbs.Likes[chirpID]) {
+			if chirpExists && authors[userID] {
+				continue
+			}
+			p := Problem{
+				Code:    ProblemOrphanedLike,
+				Table:   tableChirps,
+				Key:     chirpID,
+				Message: fmt.Sprintf("like of chirp %d by user %d refers to a missing chirp or user", chirpID, userID),
+			}
+			if repair {
+				tx.deleteLike(chirpID, userID)
+				p.Repaired = true
+			}
+			add(p)
+		}
+	}
+
+	userMax := 0
+	for key, user := range users {
+		userMax = max(userMax, key, user.ID)
+	}
+	chirpMax := 0
+	for key, chirp := range chirps {
+		chirpMax = max(chirpMax, key, chirp.ID)
+	}
+	nextIndexes := []struct {
+		table string
+		next  int
+		max   int
+	}{
+		{tableUsers, tx.dbs.UserTable.NextIndex, userMax},
+		{tableChirps, tx.dbs.ChirpTable.NextIndex, chirpMax},
+	}
+	for _, n := range nextIndexes {
+		if n.next > n.max {
+			continue
+		}
+		p := Problem{
+			Code:    ProblemNextIndex,
+			Table:   n.table,
+			Message: fmt.Sprintf("next_index is %d but the largest ID is %d", n.next, n.max),
+		}
+		if repair && tx.setNextIndex(n.table, n.max+1) == nil {
+			p.Repaired = true
+		}
+		add(p)
+	}
+
+	byEmail := map[string][]int{}
+	for _, key := range sortedKeys(users) {
+		byEmail[users[key].Email] = append(byEmail[users[key].Email], key)
+	}
+	emails := make([]string, 0, len(byEmail))
+	for email := range byEmail {
+		emails = append(emails, email)
+	}
+	slices.Sort(emails)
+	for _, email := range emails {
+		keys := byEmail[email]
+		for _, key := range keys[1:] {
+			add(Problem{
+				Code:    ProblemDuplicateEmail,
+				Table:   tableUsers,
+				Key:     key,
+				Message: fmt.Sprintf("user %d has the same email as user %d", key, keys[0]),
+			})
+		}
+	}
+
+	return report
+}
+
+// referenceProblem describes what is wrong with the chirps c refers to, or
+// returns "" if nothing is. A chirp can only refer to chirps created before
+// it, never to a rechirp, and a reply's root must be its parent's root. A
+// reference to an earlier chirp that is no longer stored is fine, since
+// purging a chirp leaves those behind.
+func (tx *Tx) referenceProblem(c Chirp) string {
+	if (c.InReplyTo == 0) != (c.RootID == 0) {
+		return fmt.Sprintf("chirp %d has in_reply_to %d but root_id %d", c.ID, c.InReplyTo, c.RootID)
+	}
+	refs := []struct {
+		field string
+		id    int
+	}{
+		{"in_reply_to", c.InReplyTo},
+		{"root_id", c.RootID},
+		{"rechirp_of", c.RechirpOf},
+		{"quote_of", c.QuoteOf},
+	}
+	for _, ref := range refs {
+		if ref.id == 0 {
+			continue
+		}
+		if ref.id < 0 || ref.id >= c.ID {
+			return fmt.Sprintf("chirp %d has %s %d, which is not an earlier chirp", c.ID, ref.field, ref.id)
+		}
+		target, ok := tx.dbs.ChirpTable.Chirps[ref.id]
+		if ok && target.RechirpOf != 0 {
+			return fmt.Sprintf("chirp %d has %s %d, which is a rechirp", c.ID, ref.field, ref.id)
+		}
+	}
+	if parent, ok := tx.dbs.ChirpTable.Chirps[c.InReplyTo]; ok {
+		if root := cmp.Or(parent.RootID, parent.ID); root != c.RootID {
+			return fmt.Sprintf("chirp %d has root_id %d but its parent %d is in conversation %d", c.ID, c.RootID, parent.ID, root)
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
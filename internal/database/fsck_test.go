@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckBadReferences(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	t.Cleanup(func() { db.Close() })
+	user, _ := db.CreateUser(ctx, "a@example.com", nil)
+	root, _ := db.CreateChirp(ctx, NewChirp{Body: "root", AuthorID: user.ID})
+	reply, _ := db.CreateChirp(ctx, NewChirp{Body: "reply", AuthorID: user.ID, InReplyTo: root.ID})
+	err := db.Update(ctx, func(tx *Tx) error {
+		now := time.Now()
+		tx.putChirp(3, Chirp{ID: 3, AuthorID: user.ID, Body: "quote", QuoteOf: 4, CreatedAt: now}, 4)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// purging the root leaves the reply referring to it, which is fine
+	err = db.DeleteChirp(ctx, root.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.PurgeDeletedChirps(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Check(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Code != ProblemBadReference || report.Problems[0].Key != 3 || !report.Problems[0].Repaired {
+		t.Fatalf("Check = %+v, want chirp 3 repaired", report.Problems)
+	}
+	_, err = db.GetChirp(ctx, 3)
+	if !errors.Is(err, ErrNotExist) {
+		t.Errorf("GetChirp of the repaired chirp: got %v, want ErrNotExist", err)
+	}
+	if _, err := db.GetChirp(ctx, reply.ID); err != nil {
+		t.Errorf("GetChirp of the reply to a purged chirp: %v", err)
+	}
+}
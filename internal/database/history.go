@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// ChirpRevision is a previous version of an edited chirp.
+type ChirpRevision struct {
+	Body string `json:"body"`
+	// CreatedAt is when this version was written, ReplacedAt when the edit
+	// that replaced it was made.
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// EditChirp replaces the body of a chirp, keeping the previous one in its
+// history. Setting the body it already has changes nothing.
+func (tx *Tx) EditChirp(id int, body string) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := tx.GetChirp(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.RechirpOf != 0 {
+		return Chirp{}, ErrRechirp
+	}
+	if chirp.Body == body {
+		return chirp, nil
+	}
+
+	now := time.Now().UTC()
+	// copy, so rolling back restores the old history untouched
+	history := append(slices.Clone(tx.dbs.ChirpHistory[id]), ChirpRevision{
+		Body:       chirp.Body,
+		CreatedAt:  chirp.UpdatedAt,
+		ReplacedAt: now,
+	})
+	tx.putChirpHistory(id, history)
+
+	chirp.Body = body
+	chirp.UpdatedAt = now
+	chirp.Edited = true
+	tx.setEntities(&chirp)
+	tx.putChirp(id, chirp, tx.dbs.ChirpTable.NextIndex)
+	return chirp, nil
+}
+
+// GetChirpHistory returns the previous versions of a chirp, oldest first.
+func (tx *Tx) GetChirpHistory(id int) ([]ChirpRevision, error) {
+	_, err := tx.GetChirp(id)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(tx.dbs.ChirpHistory[id]), nil
+}
+
+func (db *DB) EditChirp(ctx context.Context, id int, body string) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.EditChirp(id, body)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) GetChirpHistory(ctx context.Context, id int) ([]ChirpRevision, error) {
+	var history []ChirpRevision
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		history, err = tx.GetChirpHistory(id)
+		return err
+	})
+	return history, err
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// indexes are in-memory lookup structures derived from a DBStructure. They
+// are never persisted: buildIndexes recreates them after decoding, and the
+// mutation primitives in record.go keep them current.
+type indexes struct {
+	// userByEmail maps an email to the key of its user in UserTable.Users.
+	userByEmail map[string]int
+	// chirpsByAuthor maps an author ID to their chirp IDs, sorted ascending.
+	// Like chirpIDs, it leaves out deleted chirps.
+	chirpsByAuthor map[int][]int
+	// chirpIDs holds the ID of every chirp that isn't deleted, sorted
+	// ascending.
+	chirpIDs []int
+	// chirpsByHashtag and chirpsMentioning map a hashtag and a mentioned
+	// user ID to the chirps using them, sorted ascending.
+	chirpsByHashtag  map[string][]int
+	chirpsMentioning map[int][]int
+	// repliesByRoot maps the chirp that started a conversation to the
+	// replies in it, sorted ascending.
+	repliesByRoot map[int][]int
+	// rechirpsOf and quotesOf map a chirp to its rechirps and to the chirps
+	// quoting it, sorted ascending.
+	rechirpsOf map[int][]int
+	quotesOf   map[int][]int
+	// likesByUser maps a user ID to the chirps they like, sorted ascending.
+	likesByUser map[int][]int
+	// terms is the full-text index over chirp bodies: for every token, the
+	// chirps containing it and the positions it occurs at in each. Deleted
+	// chirps are left out.
+	terms map[string]map[int][]int
+}
+
+func (dbs *DBStructure) buildIndexes() {
+	idx := &indexes{
+		userByEmail:      make(map[string]int, len(dbs.UserTable.Users)),
+		chirpsByAuthor:   map[int][]int{},
+		chirpIDs:         make([]int, 0, len(dbs.ChirpTable.Chirps)),
+		chirpsByHashtag:  map[string][]int{},
+		chirpsMentioning: map[int][]int{},
+		repliesByRoot:    map[int][]int{},
+		rechirpsOf:       map[int][]int{},
+		quotesOf:         map[int][]int{},
+		likesByUser:      map[int][]int{},
+		terms:            map[string]map[int][]int{},
+	}
+	for key, user := range dbs.UserTable.Users {
+		idx.userByEmail[user.Email] = key
+	}
+	for chirpID, likes := range dbs.Likes {
+		for userID := range likes {
+			idx.likesByUser[userID] = append(idx.likesByUser[userID], chirpID)
+		}
+	}
+	for id, chirp := range dbs.ChirpTable.Chirps {
+		if chirp.Deleted() {
+			continue
+		}
+		idx.chirpIDs = append(idx.chirpIDs, id)
+		idx.chirpsByAuthor[chirp.AuthorID] = append(idx.chirpsByAuthor[chirp.AuthorID], id)
+		idx.indexText(id, chirp.Body)
+		if chirp.RootID != 0 {
+			idx.repliesByRoot[chirp.RootID] = append(idx.repliesByRoot[chirp.RootID], id)
+		}
+		if chirp.RechirpOf != 0 {
+			idx.rechirpsOf[chirp.RechirpOf] = append(idx.rechirpsOf[chirp.RechirpOf], id)
+		}
+		if chirp.QuoteOf != 0 {
+			idx.quotesOf[chirp.QuoteOf] = append(idx.quotesOf[chirp.QuoteOf], id)
+		}
+		for _, h := range chirp.Hashtags {
+			idx.chirpsByHashtag[h.Tag] = append(idx.chirpsByHashtag[h.Tag], id)
+		}
+		for _, m := range chirp.Mentions {
+			idx.chirpsMentioning[m.UserID] = append(idx.chirpsMentioning[m.UserID], id)
+		}
+	}
+	sort.Ints(idx.chirpIDs)
+	for _, ids := range idx.chirpsByAuthor {
+		sort.Ints(ids)
+	}
+	for _, ids := range idx.likesByUser {
+		sort.Ints(ids)
+	}
+	for _, byChirp := range []map[int][]int{idx.repliesByRoot, idx.rechirpsOf, idx.quotesOf} {
+		for _, ids := range byChirp {
+			sort.Ints(ids)
+		}
+	}
+	// a chirp using a tag or mentioning a user twice is listed once
+	for tag, ids := range idx.chirpsByHashtag {
+		slices.Sort(ids)
+		idx.chirpsByHashtag[tag] = slices.Compact(ids)
+	}
+	for userID, ids := range idx.chirpsMentioning {
+		slices.Sort(ids)
+		idx.chirpsMentioning[userID] = slices.Compact(ids)
+	}
+	dbs.idx = idx
+}
+
+func (idx *indexes) addChirp(key int, chirp Chirp) {
+	if chirp.Deleted() {
+		return
+	}
+	idx.chirpIDs = insertSorted(idx.chirpIDs, key)
+	idx.chirpsByAuthor[chirp.AuthorID] = insertSorted(idx.chirpsByAuthor[chirp.AuthorID], key)
+	idx.indexText(key, chirp.Body)
+	idx.indexEntities(key, chirp)
+	if chirp.RootID != 0 {
+		idx.repliesByRoot[chirp.RootID] = insertSorted(idx.repliesByRoot[chirp.RootID], key)
+	}
+	if chirp.RechirpOf != 0 {
+		idx.rechirpsOf[chirp.RechirpOf] = insertSorted(idx.rechirpsOf[chirp.RechirpOf], key)
+	}
+	if chirp.QuoteOf != 0 {
+		idx.quotesOf[chirp.QuoteOf] = insertSorted(idx.quotesOf[chirp.QuoteOf], key)
+	}
+}
+
+func (idx *indexes) removeChirp(key int, chirp Chirp) {
+	idx.chirpIDs = removeSorted(idx.chirpIDs, key)
+	ids := removeSorted(idx.chirpsByAuthor[chirp.AuthorID], key)
+	if len(ids) == 0 {
+		delete(idx.chirpsByAuthor, chirp.AuthorID)
+	} else {
+		idx.chirpsByAuthor[chirp.AuthorID] = ids
+	}
+	if !chirp.Deleted() {
+		idx.unindexText(key, chirp.Body)
+		idx.unindexEntities(key, chirp)
+		removeFrom(idx.repliesByRoot, chirp.RootID, key)
+		removeFrom(idx.rechirpsOf, chirp.RechirpOf, key)
+		removeFrom(idx.quotesOf, chirp.QuoteOf, key)
+	}
+}
+
+// removeFrom removes id from the IDs listed under key, if any.
+func removeFrom(m map[int][]int, key, id int) {
+	if key == 0 {
+		return
+	}
+	ids := removeSorted(m[key], id)
+	if len(ids) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = ids
+	}
+}
+
+// indexEntities adds a chirp to the hashtag and mention indexes. A chirp
+// using the same tag twice is only listed once.
+func (idx *indexes) indexEntities(key int, chirp Chirp) {
+	for _, h := range chirp.Hashtags {
+		idx.chirpsByHashtag[h.Tag] = insertSorted(idx.chirpsByHashtag[h.Tag], key)
+	}
+	for _, m := range chirp.Mentions {
+		idx.chirpsMentioning[m.UserID] = insertSorted(idx.chirpsMentioning[m.UserID], key)
+	}
+}
+
+func (idx *indexes) unindexEntities(key int, chirp Chirp) {
+	for _, h := range chirp.Hashtags {
+		ids := removeSorted(idx.chirpsByHashtag[h.Tag], key)
+		if len(ids) == 0 {
+			delete(idx.chirpsByHashtag, h.Tag)
+		} else {
+			idx.chirpsByHashtag[h.Tag] = ids
+		}
+	}
+	for _, m := range chirp.Mentions {
+		ids := removeSorted(idx.chirpsMentioning[m.UserID], key)
+		if len(ids) == 0 {
+			delete(idx.chirpsMentioning, m.UserID)
+		} else {
+			idx.chirpsMentioning[m.UserID] = ids
+		}
+	}
+}
+
+func (idx *indexes) indexText(key int, text string) {
+	for pos, token := range tokenize(text) {
+		postings := idx.terms[token]
+		if postings == nil {
+			postings = map[int][]int{}
+			idx.terms[token] = postings
+		}
+		postings[key] = append(postings[key], pos)
+	}
+}
+
+func (idx *indexes) unindexText(key int, text string) {
+	for _, token := range tokenize(text) {
+		postings := idx.terms[token]
+		delete(postings, key)
+		if len(postings) == 0 {
+			delete(idx.terms, token)
+		}
+	}
+}
+
+// addLike and removeLike keep Likes and likesByUser in step. Likes are not
+// rows of a table, so they maintain the index themselves.
+func (dbs *DBStructure) addLike(like Like) {
+	likes := dbs.Likes[like.ChirpID]
+	if likes == nil {
+		likes = map[int]time.Time{}
+		dbs.Likes[like.ChirpID] = likes
+	}
+	likes[like.UserID] = like.CreatedAt
+	dbs.idx.likesByUser[like.UserID] = insertSorted(dbs.idx.likesByUser[like.UserID], like.ChirpID)
+}
+
+func (dbs *DBStructure) removeLike(chirpID, userID int) {
+	likes := dbs.Likes[chirpID]
+	delete(likes, userID)
+	if len(likes) == 0 {
+		delete(dbs.Likes, chirpID)
+	}
+	removeFrom(dbs.idx.likesByUser, userID, chirpID)
+}
+
+func (idx *indexes) addUser(key int, user User) {
+	idx.userByEmail[user.Email] = key
+}
+
+func (idx *indexes) removeUser(key int, user User) {
+	if idx.userByEmail[user.Email] == key {
+		delete(idx.userByEmail, user.Email)
+	}
+}
+
+// insertSorted adds v to the sorted slice s unless it is already present.
+// New IDs are almost always the largest, which makes this an append.
+func insertSorted(s []int, v int) []int {
+	i, found := slices.BinarySearch(s, v)
+	if found {
+		return s
+	}
+	return slices.Insert(s, i, v)
+}
+
+func removeSorted(s []int, v int) []int {
+	i, found := slices.BinarySearch(s, v)
+	if !found {
+		return s
+	}
+	return slices.Delete(s, i, i+1)
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+const (
+	benchUsers  = 1000
+	benchChirps = 100000
+)
+
+// benchDB returns an in-memory DB holding benchUsers users and benchChirps
+// chirps spread evenly over them.
+func benchDB(b *testing.B) *DB {
+	b.Helper()
+	db := NewMemoryDB()
+	b.Cleanup(func() { db.Close() })
+	err := db.Update(context.Background(), func(tx *Tx) error {
+		for i := range benchUsers {
+			_, err := tx.CreateUser(fmt.Sprintf("user-%d@example.com", i), nil)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range benchChirps {
+			_, err := tx.CreateChirp(NewChirp{Body: fmt.Sprintf("chirp number %d", i), AuthorID: i%benchUsers + 1})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+// The scan sub-benchmarks do what the lookups did before the indexes, for
+// comparison.
+
+func BenchmarkGetUserByEmail(b *testing.B) {
+	db := benchDB(b)
+	ctx := context.Background()
+	email := fmt.Sprintf("user-%d@example.com", benchUsers-1)
+
+	b.Run("index", func(b *testing.B) {
+		for range b.N {
+			_, err := db.GetUserByEmail(ctx, email)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for range b.N {
+			db.View(ctx, func(tx *Tx) error {
+				for _, user := range tx.dbs.UserTable.Users {
+					if user.Email == email {
+						return nil
+					}
+				}
+				b.Fatal("user not found")
+				return nil
+			})
+		}
+	})
+}
+
+func BenchmarkQueryChirpsByAuthor(b *testing.B) {
+	db := benchDB(b)
+	ctx := context.Background()
+	const authorID = benchUsers / 2
+	const want = benchChirps / benchUsers
+
+	b.Run("index", func(b *testing.B) {
+		for range b.N {
+			chirps, err := db.QueryChirps(ctx, ChirpQuery{AuthorID: authorID})
+			if err != nil || len(chirps) != want {
+				b.Fatalf("got %d chirps, %v; want %d", len(chirps), err, want)
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for range b.N {
+			db.View(ctx, func(tx *Tx) error {
+				chirps := []Chirp{}
+				for _, chirp := range tx.dbs.ChirpTable.Chirps {
+					if chirp.AuthorID == authorID {
+						chirps = append(chirps, chirp)
+					}
+				}
+				sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+				if len(chirps) != want {
+					b.Fatalf("got %d chirps, want %d", len(chirps), want)
+				}
+				return nil
+			})
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// journalEngine keeps the database in memory and persists each transaction
+// by appending its records to a write-ahead log (path.wal). The log is
+// periodically folded into a snapshot at path, which uses the same format as
+// the file engine, so an existing database.json can be opened either way.
+// With encryption on, each record is sealed on its own line.
+type journalEngine struct {
+	file         *snapshotFile
+	walPath      string
+	compactEvery int
+
+	dbs     *DBStructure
+	wal     *os.File
+	walSize int64
+	pending int
+}
+
+func journalPath(path string) string {
+	return path + ".wal"
+}
+
+func openJournalEngine(file *snapshotFile, compactEvery int) (*journalEngine, error) {
+	walPath := journalPath(file.path)
+	// journal records are written against the schema of the snapshot they
+	// follow, so they can't be replayed onto a migrated one
+	info, err := os.Stat(walPath)
+	if err == nil && info.Size() > 0 && file.needsMigration() {
+		return nil, fmt.Errorf("database: %s must be compacted by the previous version of chirpy before %s can be migrated", walPath, file.path)
+	}
+
+	dbs, err := file.ensure()
+	if err != nil {
+		return nil, err
+	}
+
+	n, size, err := replayJournal(walPath, dbs, file.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(walPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &journalEngine{
+		file:         file,
+		walPath:      walPath,
+		compactEvery: compactEvery,
+		dbs:          dbs,
+		wal:          wal,
+		walSize:      size,
+		pending:      n,
+	}
+	if file.keys != nil && size > 0 {
+		plaintext, err := hasPlaintextRecords(walPath)
+		if err == nil && plaintext {
+			// the records were appended before encryption was turned on;
+			// folding them into the sealed snapshot is the only way to
+			// rewrite them
+			log.Printf("database: compacting %s to encrypt its records", walPath)
+			err = e.compact()
+		}
+		if err != nil {
+			wal.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// hasPlaintextRecords reports whether the log at walPath holds any record
+// that isn't encrypted.
+func hasPlaintextRecords(walPath string) (bool, error) {
+	f, err := os.Open(walPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && !isSealed(line) {
+			return true, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// replayJournal applies every complete transaction in the log at walPath to
+// dbs and returns how many records it applied and the size of the valid part
+// of the log. A transaction is complete once its commit record is in the
+// log; records after the last one, left by a crash mid-append, are
+// discarded.
+func replayJournal(walPath string, dbs *DBStructure, keys *Keyring) (int, int64, error) {
+	f, err := os.OpenFile(walPath, os.O_RDWR, 0666)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var n int
+	// offset is where the last complete transaction ends, size where the
+	// records read so far end
+	var offset, size int64
+	var pending []record
+	discard := func() (int, int64, error) {
+		log.Printf("database: discarding incomplete transaction at end of %s", walPath)
+		return n, offset, f.Truncate(offset)
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 || len(pending) > 0 {
+				return discard()
+			}
+			return n, offset, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		rec := record{}
+		data, err := keys.open(bytes.TrimSuffix(line, []byte("\n")))
+		if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrUnknownKey) {
+			return 0, 0, err
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &rec)
+		}
+		if err != nil {
+			_, peekErr := r.Peek(1)
+			if errors.Is(peekErr, io.EOF) {
+				return discard()
+			}
+			return 0, 0, fmt.Errorf("database: %s is corrupt at offset %d: %w", walPath, size, err)
+		}
+		size += int64(len(line))
+		pending = append(pending, rec)
+		if rec.Op != opCommit {
+			continue
+		}
+
+		tx := &Tx{dbs: dbs, writable: true}
+		for _, rec := range pending {
+			err = tx.apply(rec)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		n += len(pending)
+		pending = pending[:0]
+		offset = size
+	}
+}
+
+func (e *journalEngine) load() (*DBStructure, error) {
+	return e.dbs, nil
+}
+
+func (e *journalEngine) commit(dbs *DBStructure, recs []record) error {
+	buf := &bytes.Buffer{}
+	for _, rec := range recs {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		data, err = e.file.keys.seal(data)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	_, err := e.wal.Write(buf.Bytes())
+	if err == nil {
+		err = e.wal.Sync()
+	}
+	if err != nil {
+		// cut off whatever part of the batch made it to disk so the next
+		// append doesn't land after a half-written record
+		if truncErr := e.wal.Truncate(e.walSize); truncErr != nil {
+			log.Printf("database: could not truncate %s after failed append: %v", e.walPath, truncErr)
+		}
+		return err
+	}
+	e.walSize += int64(buf.Len())
+	e.pending += len(recs)
+
+	if e.pending >= e.compactEvery {
+		// the transaction is already durable, so a failed compaction must
+		// not fail the commit; the log is simply kept for longer
+		if err := e.compact(); err != nil {
+			log.Printf("database: compacting %s: %v", e.walPath, err)
+		}
+	}
+	return nil
+}
+
+func (e *journalEngine) replace(dbs *DBStructure) error {
+	old := e.dbs
+	e.dbs = dbs
+	err := e.compact()
+	if err != nil {
+		e.dbs = old
+	}
+	return err
+}
+
+// compact writes the in-memory state as a new snapshot and empties the log.
+// Records are idempotent, so a crash between the two steps only means some
+// records are replayed onto a snapshot that already contains them.
+func (e *journalEngine) compact() error {
+	err := e.file.write(e.dbs)
+	if err != nil {
+		return err
+	}
+	err = e.wal.Truncate(0)
+	if err != nil {
+		return err
+	}
+	err = e.wal.Sync()
+	if err != nil {
+		return err
+	}
+	e.walSize = 0
+	e.pending = 0
+	return nil
+}
+
+func (e *journalEngine) close() error {
+	var err error
+	if e.pending > 0 {
+		err = e.compact()
+	}
+	if closeErr := e.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayJournalDiscardsIncompleteTransaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db := openTestDB(t, EngineJournal, path)
+	user, err := db.CreateUser(ctx, "author@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp(ctx, NewChirp{Body: "first", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(journalPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.EditChirp(ctx, chirp.ID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err := os.ReadFile(journalPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the edit's history record but cut the log before its chirp and
+	// commit records, as a crash in the middle of the append would
+	edit := wal[len(before):]
+	cut := len(before) + bytes.IndexByte(edit, '\n') + 1
+
+	// the journal engine holds the lock, so copy the files out to simulate
+	// the crash without closing (and so compacting) the database
+	crashed := filepath.Join(t.TempDir(), "database.json")
+	copyFile(t, path, crashed)
+	err = os.WriteFile(journalPath(crashed), wal[:cut], 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDB(t, EngineJournal, crashed)
+	got, err := db.GetChirp(ctx, chirp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body != "first" || got.Edited {
+		t.Errorf("chirp after replay = %q, edited %v; want the unedited chirp", got.Body, got.Edited)
+	}
+	history, err := db.GetChirpHistory(ctx, chirp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("history after replay = %v, want none", history)
+	}
+	info, err := os.Stat(journalPath(crashed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(before)) {
+		t.Errorf("journal is %d bytes after replay, want it cut back to %d", info.Size(), len(before))
+	}
+}
+
+func copyFile(t testing.TB, from, to string) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(to, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
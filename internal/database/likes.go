@@ -0,0 +1,155 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+)
+
+// Like records that a user liked a chirp.
+type Like struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikeQuery selects likes for QueryLikes, newest first. One of ChirpID and
+// UserID must be set.
+type LikeQuery struct {
+	// ChirpID selects the likes of a chirp, UserID those by a user. A user's
+	// likes of deleted chirps are left out.
+	ChirpID int
+	UserID  int
+	// After continues a previous query from the like it ended with.
+	After *LikeCursor
+	// Limit caps the number of likes returned; 0 returns all of them.
+	Limit int
+}
+
+// LikeCursor is the position of a like in the results of a LikeQuery.
+type LikeCursor struct {
+	CreatedAt time.Time
+	ChirpID   int
+	UserID    int
+}
+
+func (l Like) Cursor() LikeCursor {
+	return LikeCursor{CreatedAt: l.CreatedAt, ChirpID: l.ChirpID, UserID: l.UserID}
+}
+
+// compareLikes orders likes newest first.
+func compareLikes(a, b LikeCursor) int {
+	if n := b.CreatedAt.Compare(a.CreatedAt); n != 0 {
+		return n
+	}
+	if n := cmp.Compare(b.ChirpID, a.ChirpID); n != 0 {
+		return n
+	}
+	return cmp.Compare(b.UserID, a.UserID)
+}
+
+// LikeChirp records that userID likes a chirp and returns the chirp. Liking
+// a rechirp likes the chirp it reposts. Liking a chirp twice changes
+// nothing.
+func (tx *Tx) LikeChirp(id, userID int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := tx.original(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if _, ok := tx.dbs.Likes[chirp.ID][userID]; !ok {
+		tx.putLike(Like{ChirpID: chirp.ID, UserID: userID, CreatedAt: time.Now().UTC()})
+	}
+	return chirp, nil
+}
+
+// UnlikeChirp takes back userID's like of a chirp and returns the chirp.
+// Unliking a chirp that isn't liked changes nothing.
+func (tx *Tx) UnlikeChirp(id, userID int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := tx.original(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	tx.deleteLike(chirp.ID, userID)
+	return chirp, nil
+}
+
+// QueryLikes returns the likes matching q, newest first.
+func (tx *Tx) QueryLikes(q LikeQuery) ([]Like, error) {
+	likes := []Like{}
+	if q.ChirpID != 0 {
+		for userID, likedAt := range tx.dbs.Likes[q.ChirpID] {
+			likes = append(likes, Like{ChirpID: q.ChirpID, UserID: userID, CreatedAt: likedAt})
+		}
+	} else {
+		for _, chirpID := range tx.dbs.idx.likesByUser[q.UserID] {
+			if !tx.isLive(chirpID) {
+				continue
+			}
+			likedAt := tx.dbs.Likes[chirpID][q.UserID]
+			likes = append(likes, Like{ChirpID: chirpID, UserID: q.UserID, CreatedAt: likedAt})
+		}
+	}
+	slices.SortFunc(likes, func(a, b Like) int {
+		return compareLikes(a.Cursor(), b.Cursor())
+	})
+
+	if q.After != nil {
+		start, found := slices.BinarySearchFunc(likes, *q.After, func(l Like, c LikeCursor) int {
+			return compareLikes(l.Cursor(), c)
+		})
+		if found {
+			start++
+		}
+		likes = likes[start:]
+	}
+	if q.Limit > 0 && len(likes) > q.Limit {
+		likes = likes[:q.Limit]
+	}
+	return likes, nil
+}
+
+// deleteLikes removes every like of a chirp.
+func (tx *Tx) deleteLikes(chirpID int) {
+	for _, userID := range sortedKeys(tx.dbs.Likes[chirpID]) {
+		tx.deleteLike(chirpID, userID)
+	}
+}
+
+func (db *DB) LikeChirp(ctx context.Context, id, userID int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.LikeChirp(id, userID)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) UnlikeChirp(ctx context.Context, id, userID int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.UnlikeChirp(id, userID)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) QueryLikes(ctx context.Context, q LikeQuery) ([]Like, error) {
+	var likes []Like
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		likes, err = tx.QueryLikes(q)
+		return err
+	})
+	return likes, err
+}
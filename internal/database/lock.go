@@ -0,0 +1,105 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the database lock.
+var ErrLocked = errors.New("database is locked by another process")
+
+// lockWait is how long opening a database waits for a lock held by another
+// process before giving up with ErrLocked.
+const lockWait = 2 * time.Second
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// fileLock is an advisory lock on a file next to the database, shared with
+// other processes. flock locks belong to the open file, not the goroutine,
+// so shared holders within this process are counted and the OS lock is only
+// released by the last of them.
+type fileLock struct {
+	f *os.File
+
+	mux     sync.Mutex
+	readers int
+}
+
+func openFileLock(path string) (*fileLock, error) {
+	f, err := os.OpenFile(lockPath(path), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// lockShared blocks until the lock is held shared.
+func (l *fileLock) lockShared() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.readers == 0 {
+		err := lockFile(l.f, false, true)
+		if err != nil {
+			return err
+		}
+	}
+	l.readers++
+	return nil
+}
+
+func (l *fileLock) unlockShared() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.readers--
+	if l.readers == 0 {
+		return unlockFile(l.f)
+	}
+	return nil
+}
+
+// lockExclusive blocks until the lock is held exclusively. Callers must
+// already exclude shared holders in this process.
+func (l *fileLock) lockExclusive() error {
+	return lockFile(l.f, true, true)
+}
+
+// tryLockExclusive takes the lock exclusively, waiting at most lockWait for
+// other processes to let go of it.
+func (l *fileLock) tryLockExclusive() error {
+	deadline := time.Now().Add(lockWait)
+	for {
+		err := lockFile(l.f, true, false)
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (l *fileLock) unlock() error {
+	return unlockFile(l.f)
+}
+
+func (l *fileLock) close() error {
+	return l.f.Close()
+}
+
+// withExclusiveLock runs fn while holding the lock for the database at path
+// exclusively, for operations that work on the files directly.
+func withExclusiveLock(path string, fn func() error) error {
+	l, err := openFileLock(path)
+	if err != nil {
+		return err
+	}
+	defer l.close()
+	err = l.tryLockExclusive()
+	if err != nil {
+		return err
+	}
+	defer l.unlock()
+	return fn()
+}
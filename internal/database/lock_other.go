@@ -0,0 +1,16 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// Advisory file locks are only implemented on unix; elsewhere the database
+// is only protected against concurrent use within a single process.
+
+func lockFile(f *os.File, exclusive, block bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
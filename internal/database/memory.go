@@ -0,0 +1,30 @@
+package database
+
+// memEngine keeps the database in memory only. Everything is lost when the
+// process exits, which makes it useful for tests and throwaway instances.
+type memEngine struct {
+	dbs *DBStructure
+}
+
+func (e *memEngine) load() (*DBStructure, error) {
+	return e.dbs, nil
+}
+
+func (e *memEngine) commit(dbs *DBStructure, recs []record) error {
+	return nil
+}
+
+func (e *memEngine) replace(dbs *DBStructure) error {
+	e.dbs = dbs
+	return nil
+}
+
+func (e *memEngine) close() error {
+	return nil
+}
+
+// NewMemoryDB returns an empty DB that is never written to disk.
+func NewMemoryDB() *DB {
+	db, _ := NewDB("", WithEngine(EngineMemory))
+	return db
+}
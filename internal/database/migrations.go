@@ -0,0 +1,464 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// CurrentSchemaVersion is the schema_version written by this code. It is
+// always the version of the last entry in migrations.
+const CurrentSchemaVersion = 9
+
+var (
+	ErrSchemaOutdated = errors.New("database schema is outdated")
+	ErrSchemaTooNew   = errors.New("database schema is newer than this version of chirpy")
+)
+
+// document is a database file decoded just far enough to be rewritten by
+// migrations without depending on the current Go types.
+type document map[string]json.RawMessage
+
+// migration upgrades a document from version-1 to version. apply returns a
+// human readable line for every change it made.
+type migration struct {
+	version     int
+	description string
+	apply       func(doc document) ([]string, error)
+}
+
+// migrations must stay ordered by version, and released entries must never
+// change: files in the wild were written by them.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "add schema_version and use consistent snake_case field names",
+		apply:       migrateV1,
+	},
+	{
+		version:     2,
+		description: "store the expiry of every revoked token",
+		apply:       migrateV2,
+	},
+	{
+		version:     3,
+		description: "add created_at and updated_at to chirps",
+		apply:       migrateV3,
+	},
+	{
+		version:     4,
+		description: "extract hashtags and mentions from chirp bodies",
+		apply:       migrateV4,
+	},
+	{
+		version:     5,
+		description: "add deleted_at and deleted_by to chirps",
+		apply:       versionOnly,
+	},
+	{
+		version:     6,
+		description: "add the chirp_history table",
+		apply:       addTable("chirp_history"),
+	},
+	{
+		version:     7,
+		description: "add in_reply_to and root_id to chirps",
+		apply:       versionOnly,
+	},
+	{
+		version:     8,
+		description: "add rechirp_of and quote_of to chirps",
+		apply:       versionOnly,
+	},
+	{
+		version:     9,
+		description: "add the likes table",
+		apply:       addTable("likes"),
+	},
+}
+
+// MigrationStep reports what a single migration did, or would do.
+type MigrationStep struct {
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	Changes     []string `json:"changes"`
+}
+
+func (doc document) schemaVersion() (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	var version int
+	err := json.Unmarshal(raw, &version)
+	if err != nil {
+		return 0, fmt.Errorf("database: invalid schema_version: %w", err)
+	}
+	return version, nil
+}
+
+func (doc document) rename(from, to string) bool {
+	raw, ok := doc[from]
+	if !ok {
+		return false
+	}
+	delete(doc, from)
+	doc[to] = raw
+	return true
+}
+
+// migrateDocument brings data up to CurrentSchemaVersion, returning the
+// migrated file contents, the version it started from and the steps taken.
+func migrateDocument(data []byte) ([]byte, int, []MigrationStep, error) {
+	doc := document{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	from, err := doc.schemaVersion()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if from > CurrentSchemaVersion {
+		return nil, from, nil, fmt.Errorf("database: version %d: %w", from, ErrSchemaTooNew)
+	}
+
+	steps := []MigrationStep{}
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		changes, err := m.apply(doc)
+		if err != nil {
+			return nil, from, nil, fmt.Errorf("database: migration to version %d: %w", m.version, err)
+		}
+		doc["schema_version"] = json.RawMessage(fmt.Sprint(m.version))
+		steps = append(steps, MigrationStep{
+			Version:     m.version,
+			Description: m.description,
+			Changes:     changes,
+		})
+	}
+	if len(steps) == 0 {
+		return data, from, steps, nil
+	}
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, from, nil, err
+	}
+	return migrated, from, steps, nil
+}
+
+func backupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// Migrate upgrades the database file at path to CurrentSchemaVersion and
+// returns the steps applied. The original file is copied to
+// path.v<version>.bak first. With dryRun set nothing is written and the
+// steps describe what would change. Only the options that affect how the
+// file is read and written apply.
+func Migrate(path string, dryRun bool, opts ...Option) ([]MigrationStep, error) {
+	f := newConfig(opts).snapshotFile(path)
+	var steps []MigrationStep
+	migrate := func() error {
+		data, err := f.readRaw(path)
+		if err != nil {
+			return err
+		}
+		_, steps, err = f.migrate(data, dryRun)
+		return err
+	}
+	var err error
+	if dryRun {
+		err = migrate()
+	} else {
+		err = withExclusiveLock(path, migrate)
+	}
+	return steps, err
+}
+
+func (f *snapshotFile) migrate(data []byte, dryRun bool) ([]byte, []MigrationStep, error) {
+	migrated, from, steps, err := migrateDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dryRun || len(steps) == 0 {
+		return migrated, steps, nil
+	}
+
+	backup := backupPath(f.path, from)
+	err = f.writeRaw(backup, data, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database: backing up before migration: %w", err)
+	}
+	err = f.writeRaw(f.path, migrated, f.generations)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("database: migrated %s from schema version %d to %d, backup at %s", f.path, from, CurrentSchemaVersion, backup)
+	return migrated, steps, nil
+}
+
+// needsMigration reports whether the database file is older than
+// CurrentSchemaVersion. A missing or unreadable file does not need one.
+func (f *snapshotFile) needsMigration() bool {
+	data, err := f.readRaw(f.path)
+	if err != nil {
+		return false
+	}
+	doc := document{}
+	if json.Unmarshal(data, &doc) != nil {
+		return false
+	}
+	version, err := doc.schemaVersion()
+	return err == nil && version < CurrentSchemaVersion
+}
+
+func migrateV1(doc document) ([]string, error) {
+	changes := []string{}
+	renames := [][2]string{
+		{"RevokedTokens", "revoked_tokens"},
+		{"ChirpTable", "chirp_table"},
+		{"UserTable", "user_table"},
+	}
+	for _, r := range renames {
+		if doc.rename(r[0], r[1]) {
+			changes = append(changes, fmt.Sprintf("renamed %s to %s", r[0], r[1]))
+		}
+	}
+
+	raw, ok := doc["chirp_table"]
+	if !ok {
+		return changes, nil
+	}
+	table := document{}
+	err := json.Unmarshal(raw, &table)
+	if err != nil {
+		return nil, fmt.Errorf("chirp_table: %w", err)
+	}
+	if table.rename("max_index", "next_index") {
+		changes = append(changes, "renamed chirp_table.max_index to chirp_table.next_index")
+	}
+	doc["chirp_table"], err = json.Marshal(table)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// legacyRefreshTokenLifetime is how long refresh tokens issued before
+// version 2 were valid for. It bounds the expiry of revocations whose token
+// can't be decoded.
+const legacyRefreshTokenLifetime = 60 * 24 * time.Hour
+
+func migrateV2(doc document) ([]string, error) {
+	raw, ok := doc["revoked_tokens"]
+	if !ok {
+		return nil, nil
+	}
+	old := map[string]time.Time{}
+	err := json.Unmarshal(raw, &old)
+	if err != nil {
+		return nil, fmt.Errorf("revoked_tokens: %w", err)
+	}
+
+	// entries stay keyed by the full token string: tokens issued before
+	// version 2 have no jti, so that is still what they are looked up by
+	revoked := make(map[string]RevokedToken, len(old))
+	guessed := 0
+	for token, revokedAt := range old {
+		expiresAt, ok := legacyTokenExpiry(token)
+		if !ok {
+			expiresAt = revokedAt.Add(legacyRefreshTokenLifetime)
+			guessed++
+		}
+		revoked[token] = RevokedToken{
+			RevokedAt: revokedAt,
+			ExpiresAt: expiresAt,
+		}
+	}
+	doc["revoked_tokens"], err = json.Marshal(revoked)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []string{fmt.Sprintf("added expires_at to %d revoked tokens", len(revoked))}
+	if guessed > 0 {
+		changes = append(changes, fmt.Sprintf("%d tokens had no readable exp claim and expire %s after revocation", guessed, legacyRefreshTokenLifetime))
+	}
+	return changes, nil
+}
+
+// legacyTokenExpiry reads the exp claim of a JWT without verifying it. The
+// token was verified when it was revoked; this only needs to know when the
+// revocation stops mattering.
+func legacyTokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	claims := struct {
+		ExpiresAt int64 `json:"exp"`
+	}{}
+	if json.Unmarshal(payload, &claims) != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.ExpiresAt, 0).UTC(), true
+}
+
+func migrateV3(doc document) ([]string, error) {
+	raw, ok := doc["chirp_table"]
+	if !ok {
+		return nil, nil
+	}
+	table := document{}
+	err := json.Unmarshal(raw, &table)
+	if err != nil {
+		return nil, fmt.Errorf("chirp_table: %w", err)
+	}
+	chirps := map[string]document{}
+	if raw, ok := table["chirps"]; ok {
+		err = json.Unmarshal(raw, &chirps)
+		if err != nil {
+			return nil, fmt.Errorf("chirp_table.chirps: %w", err)
+		}
+	}
+
+	// when a chirp was posted was never recorded. All we know is that it
+	// existed by now, or by the time it was deleted, and that IDs are handed
+	// out in order, which the ID tie-break keeps when sorting by created_at.
+	now, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	backfilled := 0
+	for key, chirp := range chirps {
+		if _, ok := chirp["created_at"]; ok {
+			continue
+		}
+		createdAt := json.RawMessage(now)
+		if deletedAt, ok := chirp["deleted_at"]; ok && string(deletedAt) != "null" {
+			createdAt = deletedAt
+		}
+		chirp["created_at"] = createdAt
+		chirp["updated_at"] = createdAt
+		chirps[key] = chirp
+		backfilled++
+	}
+	if backfilled == 0 {
+		return nil, nil
+	}
+
+	table["chirps"], err = json.Marshal(chirps)
+	if err != nil {
+		return nil, err
+	}
+	doc["chirp_table"], err = json.Marshal(table)
+	if err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("set created_at and updated_at of %d chirps to the time of migration, or of deletion for deleted chirps", backfilled)}, nil
+}
+
+func migrateV4(doc document) ([]string, error) {
+	raw, ok := doc["chirp_table"]
+	if !ok {
+		return nil, nil
+	}
+	table := document{}
+	err := json.Unmarshal(raw, &table)
+	if err != nil {
+		return nil, fmt.Errorf("chirp_table: %w", err)
+	}
+	chirps := map[string]document{}
+	if raw, ok := table["chirps"]; ok {
+		err = json.Unmarshal(raw, &chirps)
+		if err != nil {
+			return nil, fmt.Errorf("chirp_table.chirps: %w", err)
+		}
+	}
+
+	usersTable := struct {
+		Users map[string]struct {
+			Email string `json:"email"`
+			ID    int    `json:"id"`
+		} `json:"users"`
+	}{}
+	if raw, ok := doc["user_table"]; ok {
+		err = json.Unmarshal(raw, &usersTable)
+		if err != nil {
+			return nil, fmt.Errorf("user_table: %w", err)
+		}
+	}
+	userByEmail := map[string]int{}
+	for _, user := range usersTable.Users {
+		userByEmail[user.Email] = user.ID
+	}
+	lookup := func(email string) (int, bool) {
+		id, ok := userByEmail[email]
+		return id, ok
+	}
+
+	hashtags, mentions := 0, 0
+	for key, chirp := range chirps {
+		var body string
+		err := json.Unmarshal(chirp["body"], &body)
+		if err != nil {
+			return nil, fmt.Errorf("chirp %s: body: %w", key, err)
+		}
+		h, m := parseEntities(body, lookup)
+		chirp["hashtags"], err = json.Marshal(h)
+		if err != nil {
+			return nil, err
+		}
+		chirp["mentions"], err = json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		hashtags += len(h)
+		mentions += len(m)
+	}
+	if len(chirps) == 0 {
+		return nil, nil
+	}
+
+	table["chirps"], err = json.Marshal(chirps)
+	if err != nil {
+		return nil, err
+	}
+	doc["chirp_table"], err = json.Marshal(table)
+	if err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("found %d hashtags and %d mentions in %d chirps", hashtags, mentions, len(chirps))}, nil
+}
+
+// versionOnly is the migration for versions that only add optional fields,
+// which are absent from older rows and mean the same as their zero value
+// there. The version bump still matters: it stops older versions of chirpy
+// from opening the file and dropping the new fields on their next write.
+func versionOnly(doc document) ([]string, error) {
+	return []string{}, nil
+}
+
+// addTable returns a migration adding an empty table called name, unless
+// the document already has one.
+func addTable(name string) func(doc document) ([]string, error) {
+	return func(doc document) ([]string, error) {
+		if raw, ok := doc[name]; ok && string(raw) != "null" {
+			return []string{}, nil
+		}
+		doc[name] = json.RawMessage("{}")
+		return []string{"added empty " + name}, nil
+	}
+}
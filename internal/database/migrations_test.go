@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOlderSchemaIsMigratedAndNewerRefused(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	v4 := filepath.Join(dir, "v4.json")
+	err := os.WriteFile(v4, []byte(`{"schema_version":4,"seq":0,"revoked_tokens":{},"chirp_table":{"chirps":{},"next_index":1},"user_table":{"users":{},"next_index":1}}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := Migrate(v4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != CurrentSchemaVersion-4 || steps[len(steps)-1].Version != CurrentSchemaVersion {
+		t.Errorf("Migrate steps = %+v", steps)
+	}
+	// the migrated file must take everything added since without further ado
+	db := openTestDB(t, EngineFile, v4)
+	user, _ := db.CreateUser(ctx, "a@example.com", nil)
+	chirp, _ := db.CreateChirp(ctx, NewChirp{Body: "hi", AuthorID: user.ID})
+	_, err = db.EditChirp(ctx, chirp.ID, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.LikeChirp(ctx, chirp.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteChirp(ctx, chirp.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newer := filepath.Join(dir, "newer.json")
+	err = os.WriteFile(newer, []byte(`{"schema_version":99}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDB(newer)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("opening a newer schema: got %v, want ErrSchemaTooNew", err)
+	}
+}
@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	tableUsers   = "users"
+	tableChirps  = "chirps"
+	tableLikes   = "likes"
+	tableHistory = "chirp_history"
+)
+
+// ndjsonHeader is the first line of every exported table. It carries the
+// table's NextIndex so IDs keep counting from the same place after import;
+// tables without IDs of their own have none.
+type ndjsonHeader struct {
+	Table     string `json:"table"`
+	NextIndex int    `json:"next_index,omitempty"`
+}
+
+// historyRow is a line of an exported chirp_history table: every previous
+// version of one chirp.
+type historyRow struct {
+	ChirpID   int             `json:"chirp_id"`
+	Revisions []ChirpRevision `json:"revisions"`
+}
+
+// ImportResult reports how many rows an import added.
+type ImportResult struct {
+	Users  int `json:"users"`
+	Chirps int `json:"chirps"`
+	Likes  int `json:"likes"`
+	// Histories is the number of chirps whose edit history was imported.
+	Histories int `json:"histories"`
+}
+
+// ExportUsers writes the user table to w as newline-delimited JSON: a header
+// line followed by one user per line, ordered by ID. With stripPasswords set
+// the password hashes are left out.
+func (db *DB) ExportUsers(ctx context.Context, w io.Writer, stripPasswords bool) error {
+	return db.View(ctx, func(tx *Tx) error {
+		encoder := json.NewEncoder(w)
+		err := encoder.Encode(ndjsonHeader{Table: tableUsers, NextIndex: tx.dbs.UserTable.NextIndex})
+		if err != nil {
+			return err
+		}
+
+		users := make([]User, 0, len(tx.dbs.UserTable.Users))
+		for _, user := range tx.dbs.UserTable.Users {
+			if stripPasswords {
+				user.HashedPassword = nil
+			}
+			users = append(users, user)
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+		for _, user := range users {
+			err = encoder.Encode(user)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExportChirps writes the chirp table to w as newline-delimited JSON: a
+// header line followed by one chirp per line, ordered by ID. Deleted chirps
+// are written as they are stored, so replies and quotes of them keep their
+// place.
+func (db *DB) ExportChirps(ctx context.Context, w io.Writer) error {
+	return db.View(ctx, func(tx *Tx) error {
+		encoder := json.NewEncoder(w)
+		err := encoder.Encode(ndjsonHeader{Table: tableChirps, NextIndex: tx.dbs.ChirpTable.NextIndex})
+		if err != nil {
+			return err
+		}
+		for _, id := range sortedKeys(tx.dbs.ChirpTable.Chirps) {
+			err = encoder.Encode(tx.dbs.ChirpTable.Chirps[id])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExportLikes writes every like to w as newline-delimited JSON: a header
+// line followed by one like per line, ordered by chirp and then user.
+func (db *DB) ExportLikes(ctx context.Context, w io.Writer) error {
+	return db.View(ctx, func(tx *Tx) error {
+		encoder := json.NewEncoder(w)
+		err := encoder.Encode(ndjsonHeader{Table: tableLikes})
+		if err != nil {
+			return err
+		}
+		for _, chirpID := range sortedKeys(tx.dbs.Likes) {
+			likes := tx.dbs.Likes[chirpID]
+			for _, userID := range sortedKeys(likes) {
+				err = encoder.Encode(Like{ChirpID: chirpID, UserID: userID, CreatedAt: likes[userID]})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ExportHistory writes the edit history of every chirp to w as
+// newline-delimited JSON: a header line followed by one line per edited
+// chirp, ordered by chirp ID.
+func (db *DB) ExportHistory(ctx context.Context, w io.Writer) error {
+	return db.View(ctx, func(tx *Tx) error {
+		encoder := json.NewEncoder(w)
+		err := encoder.Encode(ndjsonHeader{Table: tableHistory})
+		if err != nil {
+			return err
+		}
+		for _, chirpID := range sortedKeys(tx.dbs.ChirpHistory) {
+			err = encoder.Encode(historyRow{ChirpID: chirpID, Revisions: tx.dbs.ChirpHistory[chirpID]})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Import adds the tables exported by ExportUsers, ExportChirps, ExportLikes
+// and ExportHistory to the database, keeping their IDs. Any reader may be
+// nil. The import is all or nothing: it is rejected if an ID is already
+// taken, if it would create two users with the same email, if a chirp's
+// author exists neither in the database nor in the import, if a chirp
+// refers to other chirps in a way Check would report, or if a like or edit
+// history is for a chirp or user that doesn't exist or is already there.
+func (db *DB) Import(ctx context.Context, users, chirps, likes, history io.Reader) (ImportResult, error) {
+	result := ImportResult{}
+	err := db.Update(ctx, func(tx *Tx) error {
+		if users != nil {
+			n, err := tx.importUsers(users)
+			if err != nil {
+				return fmt.Errorf("importing users: %w", err)
+			}
+			result.Users = n
+		}
+		if chirps != nil {
+			n, err := tx.importChirps(chirps)
+			if err != nil {
+				return fmt.Errorf("importing chirps: %w", err)
+			}
+			result.Chirps = n
+		}
+		if likes != nil {
+			n, err := tx.importLikes(likes)
+			if err != nil {
+				return fmt.Errorf("importing likes: %w", err)
+			}
+			result.Likes = n
+		}
+		if history != nil {
+			n, err := tx.importHistory(history)
+			if err != nil {
+				return fmt.Errorf("importing chirp history: %w", err)
+			}
+			result.Histories = n
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+func readHeader(decoder *json.Decoder, table string) (ndjsonHeader, error) {
+	header := ndjsonHeader{}
+	err := decoder.Decode(&header)
+	if err != nil {
+		return header, fmt.Errorf("reading header: %w", err)
+	}
+	if header.Table != table {
+		return header, fmt.Errorf("expected a %s export, got %q", table, header.Table)
+	}
+	return header, nil
+}
+
+func (tx *Tx) importUsers(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	header, err := readHeader(decoder, tableUsers)
+	if err != nil {
+		return 0, err
+	}
+
+	next := max(tx.dbs.UserTable.NextIndex, header.NextIndex)
+	n := 0
+	for line := 2; ; line++ {
+		user := User{}
+		err := decoder.Decode(&user)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, ok := tx.dbs.UserTable.Users[user.ID]; ok {
+			return 0, fmt.Errorf("line %d: user %d: %w", line, user.ID, ErrAlreadyExist)
+		}
+		if _, ok := tx.dbs.idx.userByEmail[user.Email]; ok {
+			return 0, fmt.Errorf("line %d: email %q: %w", line, user.Email, ErrAlreadyExist)
+		}
+		next = max(next, user.ID+1)
+		tx.putUser(user.ID, user, next)
+		n++
+	}
+	return n, nil
+}
+
+func (tx *Tx) importChirps(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	header, err := readHeader(decoder, tableChirps)
+	if err != nil {
+		return 0, err
+	}
+
+	next := max(tx.dbs.ChirpTable.NextIndex, header.NextIndex)
+	importedAt := time.Now().UTC()
+	n := 0
+	for line := 2; ; line++ {
+		chirp := Chirp{}
+		err := decoder.Decode(&chirp)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, ok := tx.dbs.ChirpTable.Chirps[chirp.ID]; ok {
+			return 0, fmt.Errorf("line %d: chirp %d: %w", line, chirp.ID, ErrAlreadyExist)
+		}
+		if _, err := tx.GetUserByID(chirp.AuthorID); err != nil {
+			return 0, fmt.Errorf("line %d: chirp %d has unknown author %d", line, chirp.ID, chirp.AuthorID)
+		}
+		if problem := tx.referenceProblem(chirp); problem != "" {
+			return 0, fmt.Errorf("line %d: %s", line, problem)
+		}
+		// exports from before hashtags and mentions were parsed have none
+		tx.setEntities(&chirp)
+		if chirp.CreatedAt.IsZero() {
+			// exported before chirps had timestamps
+			chirp.CreatedAt = importedAt
+			chirp.UpdatedAt = importedAt
+		}
+		next = max(next, chirp.ID+1)
+		tx.putChirp(chirp.ID, chirp, next)
+		n++
+	}
+	return n, nil
+}
+
+func (tx *Tx) importLikes(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	_, err := readHeader(decoder, tableLikes)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for line := 2; ; line++ {
+		like := Like{}
+		err := decoder.Decode(&like)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, ok := tx.dbs.ChirpTable.Chirps[like.ChirpID]; !ok {
+			return 0, fmt.Errorf("line %d: like of unknown chirp %d", line, like.ChirpID)
+		}
+		if _, err := tx.GetUserByID(like.UserID); err != nil {
+			return 0, fmt.Errorf("line %d: like by unknown user %d", line, like.UserID)
+		}
+		if _, ok := tx.dbs.Likes[like.ChirpID][like.UserID]; ok {
+			return 0, fmt.Errorf("line %d: like of chirp %d by user %d: %w", line, like.ChirpID, like.UserID, ErrAlreadyExist)
+		}
+		tx.putLike(like)
+		n++
+	}
+	return n, nil
+}
+
+func (tx *Tx) importHistory(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	_, err := readHeader(decoder, tableHistory)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for line := 2; ; line++ {
+		row := historyRow{}
+		err := decoder.Decode(&row)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, ok := tx.dbs.ChirpTable.Chirps[row.ChirpID]; !ok {
+			return 0, fmt.Errorf("line %d: edit history of unknown chirp %d", line, row.ChirpID)
+		}
+		if len(row.Revisions) == 0 {
+			return 0, fmt.Errorf("line %d: edit history of chirp %d is empty", line, row.ChirpID)
+		}
+		if _, ok := tx.dbs.ChirpHistory[row.ChirpID]; ok {
+			return 0, fmt.Errorf("line %d: edit history of chirp %d: %w", line, row.ChirpID, ErrAlreadyExist)
+		}
+		tx.putChirpHistory(row.ChirpID, row.Revisions)
+		n++
+	}
+	return n, nil
+}
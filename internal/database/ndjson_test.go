@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryDB()
+	t.Cleanup(func() { src.Close() })
+	user, _ := src.CreateUser(ctx, "a@example.com", nil)
+	root, _ := src.CreateChirp(ctx, NewChirp{Body: "root", AuthorID: user.ID})
+	reply, err := src.CreateChirp(ctx, NewChirp{Body: "reply", AuthorID: user.ID, InReplyTo: root.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = src.EditChirp(ctx, reply.ID, "edited reply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = src.LikeChirp(ctx, reply.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = src.DeleteChirp(ctx, root.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var users, chirps, likes, history bytes.Buffer
+	if err := src.ExportUsers(ctx, &users, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.ExportChirps(ctx, &chirps); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.ExportLikes(ctx, &likes); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.ExportHistory(ctx, &history); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemoryDB()
+	t.Cleanup(func() { dst.Close() })
+	result, err := dst.Import(ctx, &users, &chirps, &likes, &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (ImportResult{Users: 1, Chirps: 2, Likes: 1, Histories: 1}) {
+		t.Errorf("Import = %+v, want the user, the reply and its deleted parent, the like and the edit", result)
+	}
+	got, err := dst.GetChirp(ctx, reply.ID)
+	if err != nil || got.Body != "edited reply" || got.LikeCount != 1 {
+		t.Errorf("imported reply = %+v, %v", got, err)
+	}
+	revisions, err := dst.GetChirpHistory(ctx, reply.ID)
+	if err != nil || len(revisions) != 1 || revisions[0].Body != "reply" {
+		t.Errorf("imported history = %+v, %v", revisions, err)
+	}
+	_, err = dst.GetChirp(ctx, root.ID)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("GetChirp of the deleted parent: got %v, want ErrDeleted", err)
+	}
+	ancestors, err := dst.GetAncestors(ctx, reply.ID)
+	if err != nil || len(ancestors) != 1 || ancestors[0].DeletedAt == nil {
+		t.Errorf("GetAncestors = %+v, %v; want the parent's tombstone", ancestors, err)
+	}
+}
+
+func TestImportRejectsBadReferences(t *testing.T) {
+	const users = `{"table":"users","next_index":2}
+{"id":1,"email":"a@example.com"}
+`
+	for _, tc := range []struct {
+		name   string
+		chirps string
+	}{
+		{"later chirp", `{"table":"chirps","next_index":3}
+{"id":1,"author_id":1,"body":"reply","in_reply_to":2,"root_id":2}
+{"id":2,"author_id":1,"body":"root"}
+`},
+		{"rechirp of a rechirp", `{"table":"chirps","next_index":4}
+{"id":1,"author_id":1,"body":"original"}
+{"id":2,"author_id":1,"rechirp_of":1}
+{"id":3,"author_id":1,"rechirp_of":2}
+`},
+		{"wrong root", `{"table":"chirps","next_index":4}
+{"id":1,"author_id":1,"body":"root"}
+{"id":2,"author_id":1,"body":"reply","in_reply_to":1,"root_id":1}
+{"id":3,"author_id":1,"body":"reply","in_reply_to":2,"root_id":2}
+`},
+		{"root without parent", `{"table":"chirps","next_index":3}
+{"id":1,"author_id":1,"body":"root"}
+{"id":2,"author_id":1,"body":"reply","root_id":1}
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			t.Cleanup(func() { db.Close() })
+			_, err := db.Import(context.Background(), strings.NewReader(users), strings.NewReader(tc.chirps), nil, nil)
+			if err == nil {
+				t.Fatal("Import succeeded")
+			}
+			count, _ := db.QueryChirps(context.Background(), ChirpQuery{})
+			if len(count) != 0 {
+				t.Errorf("rejected import left %d chirps", len(count))
+			}
+		})
+	}
+}
+
+func TestImportRejectsUnknownLikesAndHistory(t *testing.T) {
+	const chirps = `{"table":"chirps","next_index":2}
+{"id":1,"author_id":1,"body":"chirp"}
+`
+	for _, tc := range []struct {
+		name           string
+		likes, history string
+	}{
+		{"like of unknown chirp", `{"table":"likes"}
+{"chirp_id":2,"user_id":1}
+`, ""},
+		{"like by unknown user", `{"table":"likes"}
+{"chirp_id":1,"user_id":2}
+`, ""},
+		{"history of unknown chirp", "", `{"table":"chirp_history"}
+{"chirp_id":2,"revisions":[{"body":"before"}]}
+`},
+		{"empty history", "", `{"table":"chirp_history"}
+{"chirp_id":1,"revisions":[]}
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			t.Cleanup(func() { db.Close() })
+			ctx := context.Background()
+			_, err := db.CreateUser(ctx, "a@example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var likes, history io.Reader
+			if tc.likes != "" {
+				likes = strings.NewReader(tc.likes)
+			}
+			if tc.history != "" {
+				history = strings.NewReader(tc.history)
+			}
+			_, err = db.Import(ctx, nil, strings.NewReader(chirps), likes, history)
+			if err == nil {
+				t.Fatal("Import succeeded")
+			}
+			if _, err := db.GetChirp(ctx, 1); !errors.Is(err, ErrNotExist) {
+				t.Errorf("rejected import left chirp 1: %v", err)
+			}
+		})
+	}
+}
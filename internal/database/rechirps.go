@@ -0,0 +1,119 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRechirp is returned when editing a rechirp, which has no body to edit.
+var ErrRechirp = errors.New("is a rechirp")
+
+// original returns the chirp id refers to, which for a rechirp is the chirp
+// it reposts. Neither may be deleted.
+func (tx *Tx) original(id int) (Chirp, error) {
+	chirp, err := tx.GetChirp(id)
+	if err != nil || chirp.RechirpOf == 0 {
+		return chirp, err
+	}
+	return tx.GetChirp(chirp.RechirpOf)
+}
+
+func (tx *Tx) isLive(id int) bool {
+	chirp, ok := tx.dbs.ChirpTable.Chirps[id]
+	return ok && !chirp.Deleted()
+}
+
+// rechirpBy returns the ID of userID's rechirp of chirpID, if they have one
+// that isn't deleted.
+func (tx *Tx) rechirpBy(chirpID, userID int) (int, bool) {
+	for _, id := range tx.dbs.idx.rechirpsOf[chirpID] {
+		if tx.dbs.ChirpTable.Chirps[id].AuthorID == userID {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// Rechirp reposts a chirp for userID. Rechirping a rechirp reposts the
+// chirp it reposts. A user can rechirp each chirp once; rechirping it again
+// returns ErrAlreadyExist.
+func (tx *Tx) Rechirp(id, userID int) (Chirp, error) {
+	if err := tx.checkWritable(); err != nil {
+		return Chirp{}, err
+	}
+	original, err := tx.original(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if _, ok := tx.rechirpBy(original.ID, userID); ok {
+		return Chirp{}, ErrAlreadyExist
+	}
+
+	now := time.Now().UTC()
+	chirp := Chirp{
+		ID:        tx.dbs.ChirpTable.NextIndex,
+		AuthorID:  userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		RechirpOf: original.ID,
+		Hashtags:  []Hashtag{},
+		Mentions:  []Mention{},
+	}
+	tx.putChirp(chirp.ID, chirp, chirp.ID+1)
+	return chirp, nil
+}
+
+// Unrechirp removes userID's rechirp of a chirp. Unlike DeleteChirp it
+// leaves no tombstone behind. It returns ErrNotExist if the user hasn't
+// rechirped the chirp.
+func (tx *Tx) Unrechirp(id, userID int) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if chirp, ok := tx.dbs.ChirpTable.Chirps[id]; ok && chirp.RechirpOf != 0 {
+		id = chirp.RechirpOf
+	}
+	rechirpID, ok := tx.rechirpBy(id, userID)
+	if !ok {
+		return ErrNotExist
+	}
+	tx.deleteChirp(rechirpID)
+	return nil
+}
+
+// fillStats works out the ChirpStats of a chirp.
+func (tx *Tx) fillStats(chirp *Chirp) {
+	if chirp.ID == 0 {
+		return
+	}
+	chirp.RechirpCount = len(tx.dbs.idx.rechirpsOf[chirp.ID])
+	chirp.QuoteCount = len(tx.dbs.idx.quotesOf[chirp.ID])
+	chirp.LikeCount = len(tx.dbs.Likes[chirp.ID])
+	original := cmp.Or(chirp.RechirpOf, chirp.QuoteOf)
+	chirp.OriginalDeleted = original != 0 && !tx.isLive(original)
+}
+
+func (tx *Tx) fillAllStats(chirps []Chirp) {
+	for i := range chirps {
+		tx.fillStats(&chirps[i])
+	}
+}
+
+func (db *DB) Rechirp(ctx context.Context, id, userID int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		chirp, err = tx.Rechirp(id, userID)
+		tx.fillStats(&chirp)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) Unrechirp(ctx context.Context, id, userID int) error {
+	return db.Update(ctx, func(tx *Tx) error {
+		return tx.Unrechirp(id, userID)
+	})
+}
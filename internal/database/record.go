@@ -0,0 +1,279 @@
+package database
+
+import "fmt"
+
+type recordOp string
+
+const (
+	opPutChirp      recordOp = "put_chirp"
+	opDeleteChirp   recordOp = "delete_chirp"
+	opPutUser       recordOp = "put_user"
+	opDeleteUser    recordOp = "delete_user"
+	opSetNextIndex  recordOp = "set_next_index"
+	opRevokeToken   recordOp = "revoke_token"
+	opUnrevokeToken recordOp = "unrevoke_token"
+	opPutHistory    recordOp = "put_chirp_history"
+	opPutLike       recordOp = "put_like"
+	opDeleteLike    recordOp = "delete_like"
+	opCommit        recordOp = "commit"
+)
+
+// record is a single mutation performed by a transaction. Records are what
+// the journal engine appends to its log, and applying them in order to the
+// state they were produced from yields the same state again. Applying a
+// record twice is harmless.
+type record struct {
+	Op         recordOp        `json:"op"`
+	Table      string          `json:"table,omitempty"`
+	Key        int             `json:"key,omitempty"`
+	NextIndex  int             `json:"next_index,omitempty"`
+	Chirp      *Chirp          `json:"chirp,omitempty"`
+	User       *User           `json:"user,omitempty"`
+	Token      string          `json:"token,omitempty"`
+	Revocation *RevokedToken   `json:"revocation,omitempty"`
+	Seq        uint64          `json:"seq,omitempty"`
+	History    []ChirpRevision `json:"history,omitempty"`
+	Like       *Like           `json:"like,omitempty"`
+}
+
+// apply replays rec against the transaction's state.
+func (tx *Tx) apply(rec record) error {
+	switch rec.Op {
+	case opPutChirp:
+		if rec.Chirp == nil {
+			return fmt.Errorf("database: %s record without chirp", rec.Op)
+		}
+		tx.putChirp(rec.Key, *rec.Chirp, rec.NextIndex)
+	case opDeleteChirp:
+		tx.deleteChirp(rec.Key)
+	case opPutUser:
+		if rec.User == nil {
+			return fmt.Errorf("database: %s record without user", rec.Op)
+		}
+		tx.putUser(rec.Key, *rec.User, rec.NextIndex)
+	case opDeleteUser:
+		tx.deleteUser(rec.Key)
+	case opSetNextIndex:
+		return tx.setNextIndex(rec.Table, rec.NextIndex)
+	case opCommit:
+		tx.setSeq(rec.Seq)
+	case opPutHistory:
+		tx.putChirpHistory(rec.Key, rec.History)
+	case opPutLike, opDeleteLike:
+		if rec.Like == nil {
+			return fmt.Errorf("database: %s record without like", rec.Op)
+		}
+		if rec.Op == opPutLike {
+			tx.putLike(*rec.Like)
+		} else {
+			tx.deleteLike(rec.Like.ChirpID, rec.Like.UserID)
+		}
+	case opRevokeToken:
+		if rec.Revocation == nil {
+			return fmt.Errorf("database: %s record without revocation", rec.Op)
+		}
+		tx.revokeToken(rec.Token, *rec.Revocation)
+	case opUnrevokeToken:
+		tx.unrevokeToken(rec.Token)
+	default:
+		return fmt.Errorf("database: unknown record op %q", rec.Op)
+	}
+	return nil
+}
+
+// The functions below are the only places that modify a DBStructure. Each
+// one keeps the indexes in sync and records the mutation for the engine and
+// an undo step for rollback.
+
+func (tx *Tx) putChirp(key int, chirp Chirp, nextIndex int) {
+	table := &tx.dbs.ChirpTable
+	idx := tx.dbs.idx
+	old, existed := table.Chirps[key]
+	oldNext := table.NextIndex
+	tx.undo = append(tx.undo, func() {
+		idx.removeChirp(key, chirp)
+		if existed {
+			table.Chirps[key] = old
+			idx.addChirp(key, old)
+		} else {
+			delete(table.Chirps, key)
+		}
+		table.NextIndex = oldNext
+	})
+
+	if existed {
+		idx.removeChirp(key, old)
+	}
+	table.Chirps[key] = chirp
+	idx.addChirp(key, chirp)
+	table.NextIndex = nextIndex
+	tx.records = append(tx.records, record{Op: opPutChirp, Key: key, NextIndex: nextIndex, Chirp: &chirp})
+}
+
+func (tx *Tx) deleteChirp(key int) {
+	table := &tx.dbs.ChirpTable
+	old, existed := table.Chirps[key]
+	if !existed {
+		return
+	}
+	idx := tx.dbs.idx
+	tx.undo = append(tx.undo, func() {
+		table.Chirps[key] = old
+		idx.addChirp(key, old)
+	})
+
+	delete(table.Chirps, key)
+	idx.removeChirp(key, old)
+	tx.records = append(tx.records, record{Op: opDeleteChirp, Key: key})
+}
+
+// putChirpHistory replaces the edit history of a chirp. An empty history
+// removes the entry.
+func (tx *Tx) putChirpHistory(key int, history []ChirpRevision) {
+	histories := tx.dbs.ChirpHistory
+	old, existed := histories[key]
+	if !existed && len(history) == 0 {
+		return
+	}
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			histories[key] = old
+		} else {
+			delete(histories, key)
+		}
+	})
+
+	if len(history) == 0 {
+		delete(histories, key)
+	} else {
+		histories[key] = history
+	}
+	tx.records = append(tx.records, record{Op: opPutHistory, Key: key, History: history})
+}
+
+func (tx *Tx) putLike(like Like) {
+	dbs := tx.dbs
+	old, existed := dbs.Likes[like.ChirpID][like.UserID]
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			dbs.addLike(Like{ChirpID: like.ChirpID, UserID: like.UserID, CreatedAt: old})
+		} else {
+			dbs.removeLike(like.ChirpID, like.UserID)
+		}
+	})
+
+	dbs.addLike(like)
+	tx.records = append(tx.records, record{Op: opPutLike, Key: like.ChirpID, Like: &like})
+}
+
+func (tx *Tx) deleteLike(chirpID, userID int) {
+	dbs := tx.dbs
+	old, existed := dbs.Likes[chirpID][userID]
+	if !existed {
+		return
+	}
+	tx.undo = append(tx.undo, func() {
+		dbs.addLike(Like{ChirpID: chirpID, UserID: userID, CreatedAt: old})
+	})
+
+	dbs.removeLike(chirpID, userID)
+	like := Like{ChirpID: chirpID, UserID: userID}
+	tx.records = append(tx.records, record{Op: opDeleteLike, Key: chirpID, Like: &like})
+}
+
+func (tx *Tx) putUser(key int, user User, nextIndex int) {
+	table := &tx.dbs.UserTable
+	idx := tx.dbs.idx
+	old, existed := table.Users[key]
+	oldNext := table.NextIndex
+	tx.undo = append(tx.undo, func() {
+		idx.removeUser(key, user)
+		if existed {
+			table.Users[key] = old
+			idx.addUser(key, old)
+		} else {
+			delete(table.Users, key)
+		}
+		table.NextIndex = oldNext
+	})
+
+	if existed {
+		idx.removeUser(key, old)
+	}
+	table.Users[key] = user
+	idx.addUser(key, user)
+	table.NextIndex = nextIndex
+	tx.records = append(tx.records, record{Op: opPutUser, Key: key, NextIndex: nextIndex, User: &user})
+}
+
+func (tx *Tx) deleteUser(key int) {
+	table := &tx.dbs.UserTable
+	old, existed := table.Users[key]
+	if !existed {
+		return
+	}
+	idx := tx.dbs.idx
+	tx.undo = append(tx.undo, func() {
+		table.Users[key] = old
+		idx.addUser(key, old)
+	})
+
+	delete(table.Users, key)
+	idx.removeUser(key, old)
+	tx.records = append(tx.records, record{Op: opDeleteUser, Key: key})
+}
+
+func (tx *Tx) setNextIndex(tableName string, nextIndex int) error {
+	var next *int
+	switch tableName {
+	case tableUsers:
+		next = &tx.dbs.UserTable.NextIndex
+	case tableChirps:
+		next = &tx.dbs.ChirpTable.NextIndex
+	default:
+		return fmt.Errorf("database: unknown table %q", tableName)
+	}
+	old := *next
+	tx.undo = append(tx.undo, func() { *next = old })
+
+	*next = nextIndex
+	tx.records = append(tx.records, record{Op: opSetNextIndex, Table: tableName, NextIndex: nextIndex})
+	return nil
+}
+
+func (tx *Tx) revokeToken(token string, revocation RevokedToken) {
+	revoked := tx.dbs.RevokedTokens
+	old, existed := revoked[token]
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			revoked[token] = old
+		} else {
+			delete(revoked, token)
+		}
+	})
+
+	revoked[token] = revocation
+	tx.records = append(tx.records, record{Op: opRevokeToken, Token: token, Revocation: &revocation})
+}
+
+func (tx *Tx) unrevokeToken(token string) {
+	revoked := tx.dbs.RevokedTokens
+	old, existed := revoked[token]
+	if !existed {
+		return
+	}
+	tx.undo = append(tx.undo, func() { revoked[token] = old })
+
+	delete(revoked, token)
+	tx.records = append(tx.records, record{Op: opUnrevokeToken, Token: token})
+}
+
+// setSeq records the sequence number of the transaction. Update adds it as
+// the last record of every transaction that changed anything.
+func (tx *Tx) setSeq(seq uint64) {
+	old := tx.dbs.Seq
+	tx.undo = append(tx.undo, func() { tx.dbs.Seq = old })
+
+	tx.dbs.Seq = seq
+	tx.records = append(tx.records, record{Op: opCommit, Seq: seq})
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultChangeBuffer is how many committed transactions a DB keeps in
+// memory for followers. A follower that falls further behind than this has
+// to start over from a snapshot.
+const DefaultChangeBuffer = 10000
+
+// maxChangesPerBatch caps how many changes a single Changes call returns.
+const maxChangesPerBatch = 1000
+
+var (
+	// ErrChangesTruncated means the changes a follower asked for are no
+	// longer buffered, or were made outside this DB, so it has to resync
+	// from a snapshot.
+	ErrChangesTruncated = errors.New("changes are no longer available, resync from a snapshot")
+	ErrChangeOutOfOrder = errors.New("change is out of order")
+)
+
+// Change is a committed transaction as shipped to followers.
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Records []record  `json:"records"`
+}
+
+// changeLog is a bounded, ordered buffer of recent changes. Waiters are
+// woken by closing notify, which is replaced on every append.
+type changeLog struct {
+	mux     sync.Mutex
+	limit   int
+	changes []Change
+	// base is the sequence number the buffer starts after.
+	base   uint64
+	notify chan struct{}
+}
+
+func newChangeLog(limit int, seq uint64) *changeLog {
+	return &changeLog{
+		limit:  limit,
+		base:   seq,
+		notify: make(chan struct{}),
+	}
+}
+
+func (l *changeLog) append(c Change) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if len(l.changes) > 0 && c.Seq != l.changes[len(l.changes)-1].Seq+1 ||
+		len(l.changes) == 0 && c.Seq != l.base+1 {
+		// something other than this DB wrote in between; what we had no
+		// longer leads up to c
+		l.changes = nil
+		l.base = c.Seq - 1
+	}
+	l.changes = append(l.changes, c)
+	if len(l.changes) > l.limit {
+		drop := len(l.changes) - l.limit
+		l.base = l.changes[drop-1].Seq
+		l.changes = append([]Change(nil), l.changes[drop:]...)
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// reset empties the buffer after the whole database was replaced.
+func (l *changeLog) reset(seq uint64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.changes = nil
+	l.base = seq
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// since returns the buffered changes after seq `after`, given that the
+// database is currently at seq. ok is false when the buffer can't bridge the
+// gap between the two.
+func (l *changeLog) since(after, seq uint64) ([]Change, <-chan struct{}, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	last := l.base
+	if len(l.changes) > 0 {
+		last = l.changes[len(l.changes)-1].Seq
+	}
+	if after > seq || after < l.base || last < seq {
+		return nil, nil, false
+	}
+	start := int(after - l.base)
+	end := min(len(l.changes), start+maxChangesPerBatch)
+	changes := append([]Change(nil), l.changes[start:end]...)
+	return changes, l.notify, true
+}
+
+// Seq returns the sequence number of the last committed transaction.
+func (db *DB) Seq(ctx context.Context) (uint64, error) {
+	var seq uint64
+	err := db.View(ctx, func(tx *Tx) error {
+		seq = tx.dbs.Seq
+		return nil
+	})
+	return seq, err
+}
+
+// Changes returns the transactions committed after seq since, oldest first,
+// along with the current sequence number. If there are none yet it waits up
+// to wait for one, or until ctx is done. It fails with ErrChangesTruncated
+// when the changes can't be provided and the caller has to resync from a
+// snapshot.
+func (db *DB) Changes(ctx context.Context, since uint64, wait time.Duration) ([]Change, uint64, error) {
+	timeout := time.After(wait)
+	for {
+		seq, err := db.Seq(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		changes, notify, ok := db.changes.since(since, seq)
+		if !ok {
+			return nil, seq, ErrChangesTruncated
+		}
+		if len(changes) > 0 || since < seq {
+			return changes, seq, nil
+		}
+
+		select {
+		case <-notify:
+		case <-timeout:
+			return changes, seq, nil
+		case <-ctx.Done():
+			return nil, seq, ctx.Err()
+		}
+	}
+}
+
+// ApplyChange replays a change received from a leader. Changes must be
+// applied in sequence order, starting right after the local Seq.
+func (db *DB) ApplyChange(ctx context.Context, c Change) error {
+	return db.update(ctx, func(tx *Tx) error {
+		if c.Seq != tx.dbs.Seq+1 {
+			return fmt.Errorf("database: at seq %d, got change %d: %w", tx.dbs.Seq, c.Seq, ErrChangeOutOfOrder)
+		}
+		for _, rec := range c.Records {
+			err := tx.apply(rec)
+			if err != nil {
+				return err
+			}
+		}
+		if tx.dbs.Seq != c.Seq {
+			return fmt.Errorf("database: change %d does not end in a %s record", c.Seq, opCommit)
+		}
+		return nil
+	}, &c)
+}
+
+// ReplaceWithSnapshot swaps the whole database for a snapshot written by
+// Snapshot, which is how a follower starts or resyncs.
+func (db *DB) ReplaceWithSnapshot(ctx context.Context, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data, err = ValidateBackup(data)
+	if err != nil {
+		return err
+	}
+	dbs, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.lockPerTx {
+		err := db.lock.lockExclusive()
+		if err != nil {
+			return err
+		}
+		defer db.lock.unlock()
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	err = db.engine.replace(dbs)
+	if err != nil {
+		return err
+	}
+	db.changes.reset(dbs.Seq)
+	return nil
+}
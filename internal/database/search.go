@@ -0,0 +1,216 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ErrEmptySearch is returned for search queries without a single word.
+var ErrEmptySearch = errors.New("search query has no words")
+
+// SearchQuery is a full-text search over chirp bodies.
+type SearchQuery struct {
+	// Text holds the words every result must contain, in any order and
+	// case. Words in double quotes must also appear next to each other, in
+	// that order.
+	Text string
+	// AuthorID restricts the results to one author; 0 searches every
+	// author.
+	AuthorID int
+	// Weights is how much each word of Text counts for in the score, as
+	// returned by SearchWeights; nil uses the current ones. They change as
+	// chirps come and go, so a search continued with the weights its first
+	// page was ranked with keeps its order.
+	Weights map[string]float64
+	// After continues a previous search from the result it ended with.
+	After *SearchCursor
+	// Limit caps the number of results; 0 returns all of them.
+	Limit int
+}
+
+// SearchResult is a chirp matching a SearchQuery along with its relevance.
+// Results are ordered by descending score, newest first among equals.
+type SearchResult struct {
+	Chirp
+	Score float64 `json:"score"`
+}
+
+// SearchCursor is the position of a result in a search.
+type SearchCursor struct {
+	Score float64
+	ID    int
+}
+
+func (r SearchResult) Cursor() SearchCursor {
+	return SearchCursor{Score: r.Score, ID: r.ID}
+}
+
+// tokenize splits text into the lower-cased runs of letters and digits the
+// full-text index is built from.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// parseSearch splits a query into its words and its quoted phrases. An
+// unterminated quote runs to the end of the query.
+func parseSearch(text string) (words []string, phrases [][]string) {
+	for i, part := range strings.Split(text, `"`) {
+		tokens := tokenize(part)
+		// odd parts were inside quotes
+		if i%2 == 1 && len(tokens) > 1 {
+			phrases = append(phrases, tokens)
+		}
+		words = append(words, tokens...)
+	}
+	slices.Sort(words)
+	return slices.Compact(words), phrases
+}
+
+// SearchWeights returns how much each word of text counts for in search
+// scores: the inverse of how many chirps use it, so rare words count for
+// more.
+func (tx *Tx) SearchWeights(text string) (map[string]float64, error) {
+	words, _ := parseSearch(text)
+	if len(words) == 0 {
+		return nil, ErrEmptySearch
+	}
+	total := float64(len(tx.dbs.idx.chirpIDs))
+	weights := make(map[string]float64, len(words))
+	for _, word := range words {
+		// a word no chirp uses yet counts as if one did
+		uses := max(len(tx.dbs.idx.terms[word]), 1)
+		weights[word] = math.Log(1 + total/float64(uses))
+	}
+	return weights, nil
+}
+
+// SearchChirps returns the chirps that aren't deleted and contain every
+// word of q.Text, ranked by how often they use the query's words, weighted
+// by q.Weights.
+func (tx *Tx) SearchChirps(q SearchQuery) ([]SearchResult, error) {
+	words, phrases := parseSearch(q.Text)
+	if len(words) == 0 {
+		return nil, ErrEmptySearch
+	}
+	weights := q.Weights
+	if weights == nil {
+		weights, _ = tx.SearchWeights(q.Text)
+	}
+	terms := tx.dbs.idx.terms
+
+	// start from the rarest word, it has the fewest candidates
+	byRarity := slices.Clone(words)
+	slices.SortFunc(byRarity, func(a, b string) int {
+		return cmp.Compare(len(terms[a]), len(terms[b]))
+	})
+	results := []SearchResult{}
+	for id := range terms[byRarity[0]] {
+		chirp := tx.dbs.ChirpTable.Chirps[id]
+		if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+			continue
+		}
+		if !tx.containsAll(id, byRarity[1:]) {
+			continue
+		}
+		if !tx.containsPhrases(id, phrases) {
+			continue
+		}
+		results = append(results, SearchResult{Chirp: chirp, Score: tx.score(id, words, weights)})
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if n := cmp.Compare(b.Score, a.Score); n != 0 {
+			return n
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	if q.After != nil {
+		after := *q.After
+		start, _ := slices.BinarySearchFunc(results, after, func(r SearchResult, c SearchCursor) int {
+			if n := cmp.Compare(c.Score, r.Score); n != 0 {
+				return n
+			}
+			return cmp.Compare(c.ID, r.ID)
+		})
+		if start < len(results) && results[start].Cursor() == after {
+			start++
+		}
+		results = results[start:]
+	}
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+func (tx *Tx) containsAll(id int, words []string) bool {
+	for _, word := range words {
+		if _, ok := tx.dbs.idx.terms[word][id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (tx *Tx) containsPhrases(id int, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		found := false
+		for _, start := range tx.dbs.idx.terms[phrase[0]][id] {
+			found = true
+			for i, word := range phrase[1:] {
+				if !slices.Contains(tx.dbs.idx.terms[word][id], start+i+1) {
+					found = false
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// score is the tf-idf of the query's words in a chirp, with the idf of each
+// word taken from weights. words must be in the same order every time, so
+// the same chirp and weights always add up to exactly the same score.
+func (tx *Tx) score(id int, words []string, weights map[string]float64) float64 {
+	score := 0.0
+	for _, word := range words {
+		score += float64(len(tx.dbs.idx.terms[word][id])) * weights[word]
+	}
+	return score
+}
+
+func (db *DB) SearchWeights(ctx context.Context, text string) (map[string]float64, error) {
+	var weights map[string]float64
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		weights, err = tx.SearchWeights(text)
+		return err
+	})
+	return weights, err
+}
+
+func (db *DB) SearchChirps(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	var results []SearchResult
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		results, err = tx.SearchChirps(q)
+		for i := range results {
+			tx.fillStats(&results[i].Chirp)
+		}
+		return err
+	})
+	return results, err
+}
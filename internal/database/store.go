@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// UserStore persists user accounts.
+type UserStore interface {
+	CreateUser(ctx context.Context, email string, hash []byte) (User, error)
+	GetUserByID(ctx context.Context, id int) (User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	UpdateUser(ctx context.Context, id int, email string, hashedPassword []byte) (User, error)
+	UpgradeUser(ctx context.Context, id int) error
+}
+
+// ChirpStore persists chirps.
+type ChirpStore interface {
+	CreateChirp(ctx context.Context, c NewChirp) (Chirp, error)
+	QueryChirps(ctx context.Context, q ChirpQuery) ([]Chirp, error)
+	SearchWeights(ctx context.Context, text string) (map[string]float64, error)
+	SearchChirps(ctx context.Context, q SearchQuery) ([]SearchResult, error)
+	HashtagUses(ctx context.Context, since time.Time) ([]HashtagUse, error)
+	GetChirp(ctx context.Context, id int) (Chirp, error)
+	GetDeletedChirp(ctx context.Context, id int) (Chirp, error)
+	GetAncestors(ctx context.Context, id int) ([]Chirp, error)
+	EditChirp(ctx context.Context, id int, body string) (Chirp, error)
+	GetChirpHistory(ctx context.Context, id int) ([]ChirpRevision, error)
+	DeleteChirp(ctx context.Context, id int, deletedBy int) error
+	Rechirp(ctx context.Context, id, userID int) (Chirp, error)
+	Unrechirp(ctx context.Context, id, userID int) error
+	LikeChirp(ctx context.Context, id, userID int) (Chirp, error)
+	UnlikeChirp(ctx context.Context, id, userID int) (Chirp, error)
+	QueryLikes(ctx context.Context, q LikeQuery) ([]Like, error)
+	RestoreChirp(ctx context.Context, id int, deletedAfter time.Time) (Chirp, error)
+	PurgeDeletedChirps(ctx context.Context, deletedBefore time.Time) (int, error)
+}
+
+// TokenStore persists the list of revoked refresh tokens.
+type TokenStore interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+	PurgeExpiredRevocations(ctx context.Context, now time.Time) (int, error)
+	RevocationCount(ctx context.Context) (int, error)
+}
+
+// ReplicationStore ships the whole store, and then every committed
+// transaction, from a leader to its followers.
+type ReplicationStore interface {
+	// Snapshot writes a consistent copy of the whole store to w.
+	Snapshot(ctx context.Context, w io.Writer) error
+	ReplaceWithSnapshot(ctx context.Context, r io.Reader) error
+	Seq(ctx context.Context) (uint64, error)
+	Changes(ctx context.Context, since uint64, wait time.Duration) ([]Change, uint64, error)
+	ApplyChange(ctx context.Context, c Change) error
+}
+
+// Store is everything the HTTP handlers need from the persistence layer.
+type Store interface {
+	UserStore
+	ChirpStore
+	TokenStore
+	ReplicationStore
+}
+
+var _ Store = (*DB)(nil)
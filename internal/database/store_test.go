@@ -0,0 +1,374 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// storeImplementations are the Store implementations the conformance suite
+// runs against, each opened empty.
+var storeImplementations = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store {
+		db := NewMemoryDB()
+		t.Cleanup(func() { db.Close() })
+		return db
+	}},
+	{"file", func(t *testing.T) Store {
+		return openTestDB(t, EngineFile, filepath.Join(t.TempDir(), "database.json"))
+	}},
+	{"journal", func(t *testing.T) Store {
+		return openTestDB(t, EngineJournal, filepath.Join(t.TempDir(), "database.json"))
+	}},
+}
+
+// storeConformance is the behaviour every Store must have.
+var storeConformance = []struct {
+	name string
+	test func(t *testing.T, ctx context.Context, s Store)
+}{
+	{"users", func(t *testing.T, ctx context.Context, s Store) {
+		user, err := s.CreateUser(ctx, "a@example.com", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != 1 || user.Email != "a@example.com" || user.IsChirpyRed {
+			t.Errorf("CreateUser = %+v", user)
+		}
+		_, err = s.CreateUser(ctx, "a@example.com", nil)
+		if !errors.Is(err, ErrAlreadyExist) {
+			t.Errorf("CreateUser with a taken email: got %v, want ErrAlreadyExist", err)
+		}
+
+		_, err = s.UpdateUser(ctx, user.ID, "b@example.com", []byte("other"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.UpgradeUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetUserByEmail(ctx, "b@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || string(got.HashedPassword) != "other" || !got.IsChirpyRed {
+			t.Errorf("GetUserByEmail after update = %+v", got)
+		}
+		_, err = s.GetUserByEmail(ctx, "a@example.com")
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByEmail with the old email: got %v, want ErrNotExist", err)
+		}
+		_, err = s.GetUserByID(ctx, 42)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByID of a missing user: got %v, want ErrNotExist", err)
+		}
+		err = s.UpgradeUser(ctx, 42)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("UpgradeUser of a missing user: got %v, want ErrNotExist", err)
+		}
+	}},
+	{"emails stay unique", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		b, _ := s.CreateUser(ctx, "b@example.com", nil)
+		_, err := s.UpdateUser(ctx, b.ID, "a@example.com", nil)
+		if !errors.Is(err, ErrAlreadyExist) {
+			t.Errorf("UpdateUser to a taken email: got %v, want ErrAlreadyExist", err)
+		}
+		_, err = s.UpdateUser(ctx, b.ID, "b@example.com", []byte("new"))
+		if err != nil {
+			t.Errorf("UpdateUser keeping its own email: %v", err)
+		}
+		_, err = s.UpdateUser(ctx, b.ID, "c@example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for email, id := range map[string]int{"a@example.com": a.ID, "c@example.com": b.ID} {
+			user, err := s.GetUserByEmail(ctx, email)
+			if err != nil || user.ID != id {
+				t.Errorf("GetUserByEmail(%s) = user %d, %v; want user %d", email, user.ID, err, id)
+			}
+		}
+		_, err = s.GetUserByEmail(ctx, "b@example.com")
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByEmail with the old email: got %v, want ErrNotExist", err)
+		}
+	}},
+	{"chirps", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		b, _ := s.CreateUser(ctx, "b@example.com", nil)
+		for _, c := range []NewChirp{
+			{Body: "one", AuthorID: a.ID},
+			{Body: "two", AuthorID: b.ID},
+			{Body: "three", AuthorID: a.ID},
+		} {
+			_, err := s.CreateChirp(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		chirps, err := s.QueryChirps(ctx, ChirpQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIDs(chirps); !slices.Equal(got, []int{1, 2, 3}) {
+			t.Errorf("QueryChirps = %v, want 1 2 3", got)
+		}
+		chirps, err = s.QueryChirps(ctx, ChirpQuery{AuthorID: a.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIDs(chirps); !slices.Equal(got, []int{1, 3}) {
+			t.Errorf("QueryChirps by author = %v, want 1 3", got)
+		}
+		chirps, err = s.QueryChirps(ctx, ChirpQuery{Descending: true, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIDs(chirps); !slices.Equal(got, []int{3, 2}) {
+			t.Errorf("QueryChirps newest two = %v, want 3 2", got)
+		}
+		chirp, err := s.GetChirp(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirp.Body != "two" || chirp.AuthorID != b.ID || chirp.CreatedAt.IsZero() {
+			t.Errorf("GetChirp = %+v", chirp)
+		}
+		_, err = s.GetChirp(ctx, 42)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetChirp of a missing chirp: got %v, want ErrNotExist", err)
+		}
+	}},
+	{"edit history", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		chirp, _ := s.CreateChirp(ctx, NewChirp{Body: "before", AuthorID: a.ID})
+		edited, err := s.EditChirp(ctx, chirp.ID, "after")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edited.Body != "after" || !edited.Edited {
+			t.Errorf("EditChirp = %+v", edited)
+		}
+		history, err := s.GetChirpHistory(ctx, chirp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 || history[0].Body != "before" {
+			t.Errorf("GetChirpHistory = %+v, want the original body", history)
+		}
+	}},
+	{"delete, restore and purge", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		chirp, _ := s.CreateChirp(ctx, NewChirp{Body: "gone", AuthorID: a.ID})
+		err := s.DeleteChirp(ctx, chirp.ID, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.GetChirp(ctx, chirp.ID)
+		if !errors.Is(err, ErrDeleted) {
+			t.Errorf("GetChirp of a deleted chirp: got %v, want ErrDeleted", err)
+		}
+		deleted, err := s.GetDeletedChirp(ctx, chirp.ID)
+		if err != nil || deleted.DeletedBy != a.ID {
+			t.Errorf("GetDeletedChirp = %+v, %v", deleted, err)
+		}
+		_, err = s.RestoreChirp(ctx, chirp.ID, time.Now().Add(time.Hour))
+		if !errors.Is(err, ErrRestoreWindowPassed) {
+			t.Errorf("RestoreChirp after the window: got %v, want ErrRestoreWindowPassed", err)
+		}
+		_, err = s.RestoreChirp(ctx, chirp.ID, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.GetChirp(ctx, chirp.ID)
+		if err != nil {
+			t.Errorf("GetChirp of a restored chirp: %v", err)
+		}
+
+		err = s.DeleteChirp(ctx, chirp.ID, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		purged, err := s.PurgeDeletedChirps(ctx, time.Now().Add(time.Hour))
+		if err != nil || purged != 1 {
+			t.Errorf("PurgeDeletedChirps = %d, %v; want 1", purged, err)
+		}
+		_, err = s.GetChirp(ctx, chirp.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetChirp of a purged chirp: got %v, want ErrNotExist", err)
+		}
+	}},
+	{"ancestors of deleted and purged chirps", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		root, _ := s.CreateChirp(ctx, NewChirp{Body: "root", AuthorID: a.ID})
+		middle, _ := s.CreateChirp(ctx, NewChirp{Body: "middle", AuthorID: a.ID, InReplyTo: root.ID})
+		leaf, err := s.CreateChirp(ctx, NewChirp{Body: "leaf", AuthorID: a.ID, InReplyTo: middle.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.DeleteChirp(ctx, middle.ID, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ancestors, err := s.GetAncestors(ctx, leaf.ID)
+		if err != nil || !slices.Equal(chirpIDs(ancestors), []int{root.ID, middle.ID}) {
+			t.Fatalf("GetAncestors = %v, %v; want the root and the deleted middle", chirpIDs(ancestors), err)
+		}
+		if tomb := ancestors[1]; !tomb.Deleted() || tomb.Body != "" || tomb.AuthorID != 0 {
+			t.Errorf("deleted ancestor = %+v, want a tombstone", tomb)
+		}
+
+		_, err = s.PurgeDeletedChirps(ctx, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ancestors, err = s.GetAncestors(ctx, leaf.ID)
+		if err != nil || len(ancestors) != 0 {
+			t.Errorf("GetAncestors = %+v, %v; want none above the purged middle", ancestors, err)
+		}
+	}},
+	{"replies, rechirps and likes", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		b, _ := s.CreateUser(ctx, "b@example.com", nil)
+		root, _ := s.CreateChirp(ctx, NewChirp{Body: "root", AuthorID: a.ID})
+		reply, err := s.CreateChirp(ctx, NewChirp{Body: "reply", AuthorID: b.ID, InReplyTo: root.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.InReplyTo != root.ID || reply.RootID != root.ID {
+			t.Errorf("reply = %+v", reply)
+		}
+		ancestors, err := s.GetAncestors(ctx, reply.ID)
+		if err != nil || !slices.Equal(chirpIDs(ancestors), []int{root.ID}) {
+			t.Errorf("GetAncestors = %v, %v", chirpIDs(ancestors), err)
+		}
+
+		_, err = s.Rechirp(ctx, root.ID, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Rechirp(ctx, root.ID, b.ID)
+		if !errors.Is(err, ErrAlreadyExist) {
+			t.Errorf("second Rechirp: got %v, want ErrAlreadyExist", err)
+		}
+		_, err = s.LikeChirp(ctx, root.ID, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		liked, err := s.LikeChirp(ctx, root.ID, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if liked.LikeCount != 1 || liked.RechirpCount != 1 {
+			t.Errorf("counts after liking twice = %d likes, %d rechirps; want 1 and 1", liked.LikeCount, liked.RechirpCount)
+		}
+		err = s.Unrechirp(ctx, root.ID, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unliked, err := s.UnlikeChirp(ctx, root.ID, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unliked.LikeCount != 0 || unliked.RechirpCount != 0 {
+			t.Errorf("counts after undoing = %d likes, %d rechirps; want none", unliked.LikeCount, unliked.RechirpCount)
+		}
+	}},
+	{"search pages keep their ranking", func(t *testing.T, ctx context.Context, s Store) {
+		a, _ := s.CreateUser(ctx, "a@example.com", nil)
+		first, _ := s.CreateChirp(ctx, NewChirp{Body: "hello hello world", AuthorID: a.ID})
+		second, _ := s.CreateChirp(ctx, NewChirp{Body: "hello world world", AuthorID: a.ID})
+		s.CreateChirp(ctx, NewChirp{Body: "world", AuthorID: a.ID})
+
+		weights, err := s.SearchWeights(ctx, "hello world")
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := SearchQuery{Text: "hello world", Weights: weights, Limit: 1}
+		page, err := s.SearchChirps(ctx, query)
+		if err != nil || len(page) != 1 || page[0].ID != first.ID {
+			t.Fatalf("first page = %+v, %v; want chirp %d", page, err, first.ID)
+		}
+		// make hello common and world rare, which would rank second first
+		for range 10 {
+			s.CreateChirp(ctx, NewChirp{Body: "hello", AuthorID: a.ID})
+		}
+
+		cursor := page[0].Cursor()
+		query.After = &cursor
+		page, err = s.SearchChirps(ctx, query)
+		if err != nil || len(page) != 1 || page[0].ID != second.ID {
+			t.Errorf("second page = %+v, %v; want chirp %d", page, err, second.ID)
+		}
+	}},
+	{"revoked tokens", func(t *testing.T, ctx context.Context, s Store) {
+		now := time.Now()
+		err := s.RevokeToken(ctx, "expired", now.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.RevokeToken(ctx, "valid", now.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		revoked, err := s.IsRevoked(ctx, "valid")
+		if err != nil || !revoked {
+			t.Errorf("IsRevoked(valid) = %v, %v; want true", revoked, err)
+		}
+		purged, err := s.PurgeExpiredRevocations(ctx, now)
+		if err != nil || purged != 1 {
+			t.Errorf("PurgeExpiredRevocations = %d, %v; want 1", purged, err)
+		}
+		count, err := s.RevocationCount(ctx)
+		if err != nil || count != 1 {
+			t.Errorf("RevocationCount = %d, %v; want 1", count, err)
+		}
+		revoked, _ = s.IsRevoked(ctx, "expired")
+		if revoked {
+			t.Error("IsRevoked(expired) after purging = true")
+		}
+	}},
+	{"sequence numbers", func(t *testing.T, ctx context.Context, s Store) {
+		_, err := s.CreateUser(ctx, "a@example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, err := s.Seq(ctx)
+		if err != nil || seq != 1 {
+			t.Errorf("Seq = %d, %v; want 1", seq, err)
+		}
+		changes, seq, err := s.Changes(ctx, 0, 0)
+		if err != nil || seq != 1 || len(changes) != 1 || changes[0].Seq != 1 {
+			t.Errorf("Changes(0) = %+v, %d, %v", changes, seq, err)
+		}
+	}},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, impl := range storeImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			for _, tc := range storeConformance {
+				t.Run(tc.name, func(t *testing.T) {
+					tc.test(t, context.Background(), impl.open(t))
+				})
+			}
+		})
+	}
+}
+
+func chirpIDs(chirps []Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"slices"
+)
+
+// rootOf returns the ID of the chirp that started the conversation id is
+// part of.
+func (tx *Tx) rootOf(id int) int {
+	chirp, ok := tx.dbs.ChirpTable.Chirps[id]
+	if !ok || chirp.RootID == 0 {
+		return id
+	}
+	return chirp.RootID
+}
+
+// descendsFrom reports whether chirp is a reply to ancestorID, directly or
+// through other replies. Deleted replies in between still count, purged
+// ones break the chain.
+func (tx *Tx) descendsFrom(chirp Chirp, ancestorID int) bool {
+	for chirp.InReplyTo != 0 {
+		if chirp.InReplyTo == ancestorID {
+			return true
+		}
+		parent, ok := tx.dbs.ChirpTable.Chirps[chirp.InReplyTo]
+		if !ok {
+			return false
+		}
+		chirp = parent
+	}
+	return false
+}
+
+// GetAncestors returns the chirps a reply is replying to, starting from the
+// one that started the conversation and ending with its direct parent.
+// Deleted chirps are included as tombstones without their contents, so the
+// conversation still makes sense. A chirp that was purged entirely ends the
+// chain and is left out, along with everything above it; the first chirp
+// returned then replies to a chirp that no longer exists.
+func (tx *Tx) GetAncestors(id int) ([]Chirp, error) {
+	chirp, err := tx.GetChirp(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors := []Chirp{}
+	for chirp.InReplyTo != 0 {
+		parent, ok := tx.dbs.ChirpTable.Chirps[chirp.InReplyTo]
+		if !ok {
+			break
+		}
+		if parent.Deleted() {
+			parent = parent.tombstone()
+		}
+		ancestors = append(ancestors, parent)
+		chirp = parent
+	}
+	slices.Reverse(ancestors)
+	return ancestors, nil
+}
+
+// tombstone strips a deleted chirp down to its place in the conversation.
+func (c Chirp) tombstone() Chirp {
+	return Chirp{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		InReplyTo: c.InReplyTo,
+		RootID:    c.RootID,
+		Hashtags:  []Hashtag{},
+		Mentions:  []Mention{},
+		DeletedAt: c.DeletedAt,
+	}
+}
+
+func (db *DB) GetAncestors(ctx context.Context, id int) ([]Chirp, error) {
+	var ancestors []Chirp
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		ancestors, err = tx.GetAncestors(id)
+		tx.fillAllStats(ancestors)
+		return err
+	})
+	return ancestors, err
+}
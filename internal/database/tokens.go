@@ -1,28 +1,86 @@
 package database
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
-func (db *DB) RevokeToken(token string) error {
-	dbs, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	dbs.RevokedTokens[token] = time.Now().UTC()
+// RevokedToken is an entry in the revocation list. Entries are keyed by the
+// token's ID (its jti claim) and can be dropped once the token has expired,
+// since an expired token is rejected anyway.
+type RevokedToken struct {
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
 
-	err = db.writeDB(dbs)
-	if err != nil {
+func (tx *Tx) RevokeToken(tokenID string, expiresAt time.Time) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
+	tx.revokeToken(tokenID, RevokedToken{
+		RevokedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	})
 	return nil
 }
 
-func (db *DB) IsRevoked(token string) (bool, error) {
-	dbs, err := db.loadDB()
-	if err != nil {
-		return false, err
+func (tx *Tx) IsRevoked(tokenID string) (bool, error) {
+	_, ok := tx.dbs.RevokedTokens[tokenID]
+	return ok, nil
+}
+
+// PurgeExpiredRevocations removes every revocation whose token expired
+// before now and returns how many were removed.
+func (tx *Tx) PurgeExpiredRevocations(now time.Time) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	purged := 0
+	for tokenID, revoked := range tx.dbs.RevokedTokens {
+		if revoked.ExpiresAt.Before(now) {
+			tx.unrevokeToken(tokenID)
+			purged++
+		}
 	}
+	return purged, nil
+}
 
-	_, ok := dbs.RevokedTokens[token]
-	return ok, nil
+func (tx *Tx) RevocationCount() (int, error) {
+	return len(tx.dbs.RevokedTokens), nil
+}
+
+func (db *DB) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	return db.Update(ctx, func(tx *Tx) error {
+		return tx.RevokeToken(tokenID, expiresAt)
+	})
+}
+
+func (db *DB) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		revoked, err = tx.IsRevoked(tokenID)
+		return err
+	})
+	return revoked, err
+}
+
+func (db *DB) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int, error) {
+	var purged int
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		purged, err = tx.PurgeExpiredRevocations(now)
+		return err
+	})
+	return purged, err
+}
+
+func (db *DB) RevocationCount(ctx context.Context) (int, error) {
+	var count int
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		count, err = tx.RevocationCount()
+		return err
+	})
+	return count, err
 }
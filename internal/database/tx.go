@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Tx is a transaction over the whole database. A Tx is only valid inside the
+// function passed to DB.View or DB.Update and must not be retained.
+type Tx struct {
+	dbs      *DBStructure
+	writable bool
+	records  []record
+	undo     []func()
+}
+
+var ErrTxReadOnly = errors.New("transaction is read-only")
+
+// View runs fn with a read-only transaction. Any number of View calls may run
+// concurrently, but never alongside an Update. If ctx is done before the
+// transaction starts, fn is not run.
+func (db *DB) View(ctx context.Context, fn func(tx *Tx) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if db.lockPerTx {
+		err := db.lock.lockShared()
+		if err != nil {
+			return err
+		}
+		defer db.lock.unlockShared()
+	}
+
+	// waiting for the locks may have taken a while, and loading can mean
+	// reading and decoding the whole file
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	dbs, err := db.engine.load()
+	if err != nil {
+		return err
+	}
+	return fn(&Tx{dbs: dbs})
+}
+
+// Update runs fn with a writable transaction. The lock is held across the
+// whole load/mutate/write cycle, so concurrent updates never observe each
+// other's partial state or lose writes. If fn returns an error, ctx is done
+// before the change is persisted, or the change cannot be persisted, the
+// transaction is rolled back.
+func (db *DB) Update(ctx context.Context, fn func(tx *Tx) error) error {
+	return db.update(ctx, fn, nil)
+}
+
+// update runs a writable transaction. A transaction that changes anything
+// gets the next sequence number and is added to the change log. When
+// replaying a change from a leader, fn applies its records, commit record
+// included, and replicated is that change.
+func (db *DB) update(ctx context.Context, fn func(tx *Tx) error, replicated *Change) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.lockPerTx {
+		err := db.lock.lockExclusive()
+		if err != nil {
+			return err
+		}
+		defer db.lock.unlock()
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	dbs, err := db.engine.load()
+	if err != nil {
+		return err
+	}
+	tx := &Tx{dbs: dbs, writable: true}
+	err = fn(tx)
+	if err != nil || len(tx.records) == 0 {
+		tx.rollback()
+		return err
+	}
+	// last chance to back out: once the commit starts it runs to the end
+	err = ctx.Err()
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+
+	change := Change{Seq: dbs.Seq + 1, Time: time.Now().UTC()}
+	if replicated != nil {
+		change = *replicated
+	} else {
+		tx.setSeq(change.Seq)
+	}
+	change.Records = tx.records
+	err = db.engine.commit(dbs, tx.records)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	db.changes.append(change)
+	return nil
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+	tx.records = nil
+}
+
+func (tx *Tx) checkWritable() error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
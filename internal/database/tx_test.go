@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testEngines are the engines every engine-independent test runs against.
+var testEngines = []EngineType{EngineMemory, EngineFile, EngineJournal}
+
+// openTestDB opens a DB with engine at path, failing the test on error and
+// closing it when the test ends. Closing twice is harmless, so tests may
+// close it themselves to reopen the same path.
+func openTestDB(t testing.TB, engine EngineType, path string, opts ...Option) *DB {
+	t.Helper()
+	db, err := NewDB(path, append([]Option{WithEngine(engine)}, opts...)...)
+	if err != nil {
+		t.Fatalf("opening %s database: %v", engine, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestUpdateConcurrentNoLostWrites(t *testing.T) {
+	const workers = 16
+	const perWorker = 25
+
+	for _, engine := range testEngines {
+		t.Run(string(engine), func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "database.json")
+			// compact mid-run too, so it is exercised under contention
+			db := openTestDB(t, engine, path, WithCompactEvery(100))
+
+			author, err := db.CreateUser(ctx, "author@example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, workers*perWorker*2)
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range perWorker {
+						_, err := db.CreateChirp(ctx, NewChirp{Body: fmt.Sprintf("chirp %d/%d", w, i), AuthorID: author.ID})
+						if err != nil {
+							errs <- err
+						}
+						_, err = db.CreateUser(ctx, fmt.Sprintf("user-%d-%d@example.com", w, i), nil)
+						if err != nil {
+							errs <- err
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			checkContiguous(t, db, workers*perWorker, workers*perWorker+1)
+			if engine == EngineMemory {
+				return
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			checkContiguous(t, openTestDB(t, engine, path), workers*perWorker, workers*perWorker+1)
+		})
+	}
+}
+
+// checkContiguous checks that the database holds exactly chirps chirps and
+// users users, with IDs 1 to n and NextIndex right after them.
+func checkContiguous(t *testing.T, db *DB, chirps, users int) {
+	t.Helper()
+	err := db.View(context.Background(), func(tx *Tx) error {
+		for _, table := range []struct {
+			name string
+			ids  []int
+			next int
+			want int
+		}{
+			{tableChirps, sortedKeys(tx.dbs.ChirpTable.Chirps), tx.dbs.ChirpTable.NextIndex, chirps},
+			{tableUsers, sortedKeys(tx.dbs.UserTable.Users), tx.dbs.UserTable.NextIndex, users},
+		} {
+			want := make([]int, table.want)
+			for i := range want {
+				want[i] = i + 1
+			}
+			if !slices.Equal(table.ids, want) {
+				t.Errorf("%s: got IDs %v, want 1 to %d", table.name, table.ids, table.want)
+			}
+			if table.next != table.want+1 {
+				t.Errorf("%s: next_index is %d, want %d", table.name, table.next, table.want+1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateCancelledDoesNotCommit(t *testing.T) {
+	for _, engine := range testEngines {
+		t.Run(string(engine), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "database.json")
+			db := openTestDB(t, engine, path)
+			_, err := db.CreateUser(context.Background(), "author@example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			onDisk := readIfExists(t, path, journalPath(path))
+
+			cancelled, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err = db.CreateChirp(cancelled, NewChirp{Body: "too late", AuthorID: 1})
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("CreateChirp with a cancelled context: got %v, want context.Canceled", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			err = db.Update(ctx, func(tx *Tx) error {
+				_, err := tx.CreateChirp(NewChirp{Body: "cancelled midway", AuthorID: 1})
+				if err != nil {
+					return err
+				}
+				cancel()
+				return nil
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Update cancelled inside fn: got %v, want context.Canceled", err)
+			}
+
+			checkContiguous(t, db, 0, 1)
+			seq, err := db.Seq(context.Background())
+			if err != nil || seq != 1 {
+				t.Errorf("Seq = %d, %v; want 1", seq, err)
+			}
+			if after := readIfExists(t, path, journalPath(path)); !maps.Equal(after, onDisk) {
+				t.Error("files on disk changed")
+			}
+		})
+	}
+}
+
+// readIfExists returns the contents of the paths that exist.
+func readIfExists(t *testing.T, paths ...string) map[string]string {
+	t.Helper()
+	contents := map[string]string{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[path] = string(data)
+	}
+	return contents
+}
@@ -1,6 +1,9 @@
 package database
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 type UserTable struct {
 	Users     map[int]User `json:"users"`
@@ -16,102 +19,129 @@ type User struct {
 
 var ErrAlreadyExist = errors.New("already exitst")
 
-func (db *DB) CreateUser(email string, hash []byte) (User, error) {
-	dbs, err := db.loadDB()
-	if err != nil {
+func (tx *Tx) CreateUser(email string, hash []byte) (User, error) {
+	if err := tx.checkWritable(); err != nil {
 		return User{}, err
 	}
 
 	user := User{
-		ID:             dbs.UserTable.NextIndex,
+		ID:             tx.dbs.UserTable.NextIndex,
 		Email:          email,
 		IsChirpyRed:    false,
 		HashedPassword: hash,
 	}
-	_, err = db.GetUserByEmail(user.Email)
-	if err != nil && !errors.Is(err, ErrNotExist) {
+	// check and insert happen in the same transaction, so two concurrent
+	// signups with the same email cannot both succeed
+	_, err := tx.GetUserByEmail(user.Email)
+	if err == nil {
 		return User{}, ErrAlreadyExist
 	}
-
-	dbs.UserTable.Users[dbs.ChirpTable.NextIndex] = user
-	dbs.UserTable.NextIndex++
-
-	err = db.writeDB(dbs)
-	if err != nil {
+	if !errors.Is(err, ErrNotExist) {
 		return User{}, err
 	}
 
+	tx.putUser(user.ID, user, user.ID+1)
+
 	return user, nil
 }
 
-func (db *DB) GetUserByID(id int) (User, error) {
-	dbs, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	user, ok := dbs.UserTable.Users[id]
+func (tx *Tx) GetUserByID(id int) (User, error) {
+	user, ok := tx.dbs.UserTable.Users[id]
 	if !ok {
 		return User{}, ErrNotExist
 	}
 	return user, nil
 }
 
-func (db *DB) GetUserByEmail(email string) (User, error) {
-	dbs, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	for _, user := range dbs.UserTable.Users {
-		if user.Email == email {
-			return user, nil
-		}
+func (tx *Tx) GetUserByEmail(email string) (User, error) {
+	key, ok := tx.dbs.idx.userByEmail[email]
+	if !ok {
+		return User{}, ErrNotExist
 	}
-	return User{}, ErrNotExist
+	return tx.dbs.UserTable.Users[key], nil
 }
 
-func (db *DB) UpdateUser(id int, email string, hashedPassword []byte) (User, error) {
-	dbs, err := db.loadDB()
-	if err != nil {
+func (tx *Tx) UpdateUser(id int, email string, hashedPassword []byte) (User, error) {
+	if err := tx.checkWritable(); err != nil {
 		return User{}, err
 	}
 
-	user, err := db.GetUserByID(id)
+	user, err := tx.GetUserByID(id)
 	if err != nil {
 		return User{}, err
 	}
+	// emails are unique, like in CreateUser
+	if other, err := tx.GetUserByEmail(email); err == nil && other.ID != id {
+		return User{}, ErrAlreadyExist
+	}
 
 	user.Email = email
 	user.HashedPassword = hashedPassword
 
-	dbs.UserTable.Users[id] = user
-
-	err = db.writeDB(dbs)
-	if err != nil {
-		return User{}, err
-	}
+	tx.putUser(id, user, tx.dbs.UserTable.NextIndex)
 
 	return user, nil
 }
 
-func (db *DB) UpgradeUser(id int) error {
-	dbs, err := db.loadDB()
-	if err != nil {
+func (tx *Tx) UpgradeUser(id int) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
 
-	user, err := db.GetUserByID(id)
+	user, err := tx.GetUserByID(id)
 	if err != nil {
 		return err
 	}
 
 	user.IsChirpyRed = true
 
-	dbs.UserTable.Users[id] = user
+	tx.putUser(id, user, tx.dbs.UserTable.NextIndex)
 
-	err = db.writeDB(dbs)
-	if err != nil {
+	return nil
+}
+
+func (db *DB) CreateUser(ctx context.Context, email string, hash []byte) (User, error) {
+	var user User
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		user, err = tx.CreateUser(email, hash)
 		return err
-	}
+	})
+	return user, err
+}
 
-	return nil
+func (db *DB) GetUserByID(ctx context.Context, id int) (User, error) {
+	var user User
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUserByID(id)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := db.View(ctx, func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUserByEmail(email)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) UpdateUser(ctx context.Context, id int, email string, hashedPassword []byte) (User, error) {
+	var user User
+	err := db.Update(ctx, func(tx *Tx) error {
+		var err error
+		user, err = tx.UpdateUser(id, email, hashedPassword)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) UpgradeUser(ctx context.Context, id int) error {
+	return db.Update(ctx, func(tx *Tx) error {
+		return tx.UpgradeUser(id)
+	})
 }
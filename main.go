@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/ammon134/chirpy/internal/database"
@@ -13,15 +19,24 @@ import (
 
 const (
 	filePathRoot = "."
-	port         = "8080"
+	defaultPort  = "8080"
 	dbPath       = "database.json"
+	// requestTimeout bounds how long a request may run, matching the
+	// server's write timeout after which its response could not be sent
+	requestTimeout = 10 * time.Second
 )
 
 type apiConfig struct {
-	db           *database.DB
+	db           database.Store
 	jwtSecret    string
 	polka_apikey string
+	adminAPIKey  string
 	serverHits   int
+	sweeper      *revocationSweeper
+	purger       *chirpPurger
+	trending     *trendingTracker
+	// replicator is set when this server follows a leader
+	replicator *replicator
 }
 
 func main() {
@@ -30,15 +45,26 @@ func main() {
 		log.Fatal(err)
 	}
 
-	mux := http.NewServeMux()
-	corsMux := middlewareCors(mux)
+	if len(os.Args) > 1 {
+		err = runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	port := getenvDefault("PORT", defaultPort)
 	server := &http.Server{
 		Addr:         ":" + port,
-		Handler:      corsMux,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: requestTimeout,
 	}
-	db, err := database.NewDB(dbPath)
+	// cancelled as soon as shutdown starts, so long polls for replication
+	// changes don't hold it up
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	server.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	server.RegisterOnShutdown(cancelBase)
+	db, err := openDB(getenvDefault("DB_PATH", dbPath))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -46,36 +72,60 @@ func main() {
 		db:           db,
 		jwtSecret:    os.Getenv("JWT_SECRET"),
 		polka_apikey: os.Getenv("POLKA_APIKEY"),
+		adminAPIKey:  os.Getenv("ADMIN_APIKEY"),
 		serverHits:   0,
+		sweeper:      &revocationSweeper{db: db},
+		purger:       &chirpPurger{db: db},
+		trending:     &trendingTracker{db: db},
 	}
+	// followers rank what they have replicated themselves
+	go apiConfig.trending.run(trendingInterval)
 
-	mux.Handle("/app/*", apiConfig.middlewareHitInc(http.StripPrefix("/app/", http.FileServer(http.Dir(filePathRoot)))))
-
-	mux.Handle("GET /api/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(http.StatusText(http.StatusOK)))
-	}))
-	mux.HandleFunc("GET /admin/metrics", apiConfig.handlerMetrics)
-	mux.HandleFunc("GET /api/reset", apiConfig.handlerReset)
-
-	mux.HandleFunc("POST /api/chirps", apiConfig.handlerCreateChirp)
-	mux.HandleFunc("GET /api/chirps", apiConfig.handlerGetChirps)
-	mux.HandleFunc("GET /api/chirps/{id}", apiConfig.handlerGetChirp)
-	mux.HandleFunc("DELETE /api/chirps/{id}", apiConfig.handlerDeleteChirp)
-
-	mux.HandleFunc("POST /api/users", apiConfig.handlerCreateUser)
-	mux.HandleFunc("PUT /api/users", apiConfig.handlerUpdateUser)
-
-	mux.HandleFunc("POST /api/login", apiConfig.handlerLogin)
-
-	mux.HandleFunc("POST /api/revoke", apiConfig.handlerRevokeToken)
-	mux.HandleFunc("POST /api/refresh", apiConfig.handlerRefreshToken)
+	if leader := os.Getenv("REPLICATION_LEADER_URL"); leader != "" {
+		leaderURL, err := url.Parse(leader)
+		if err != nil {
+			log.Fatalf("REPLICATION_LEADER_URL: %s", err)
+		}
+		// a follower's store only changes through replication, so expired
+		// revocations and deleted chirps are purged by the leader and writes
+		// go there
+		apiConfig.replicator = newReplicator(db, leaderURL, os.Getenv("REPLICATION_LEADER_APIKEY"))
+		go apiConfig.replicator.run(baseCtx)
+	} else {
+		go apiConfig.sweeper.run(revocationSweepInterval)
+		go apiConfig.purger.run(chirpPurgeInterval)
+	}
+	server.Handler = apiConfig.handler()
 
-	mux.HandleFunc("POST /api/polka/webhooks", apiConfig.handlerWebhookUpgradeUser)
+	go func() {
+		fmt.Printf("listening on port %s...\n", port)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// shut down cleanly so the storage engine gets a chance to flush
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("shutting down server: %s", err)
+	}
+	err = db.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	fmt.Printf("listening on port %s...\n", port)
-	log.Fatal(server.ListenAndServe())
+func getenvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }
 
 func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
@@ -99,6 +149,16 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "Hits reset to 0")
 }
 
+// middlewareTimeout cancels the request's context after timeout, so the
+// database stops working on requests whose responses can no longer be sent.
+func middlewareTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func middlewareCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -111,3 +171,65 @@ func middlewareCors(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// handler routes every endpoint. On a follower, requests that could change
+// something are forwarded to the leader.
+func (apiConfig *apiConfig) handler() http.Handler {
+	mux := http.NewServeMux()
+	// long polls for replication changes set their own deadline; everything
+	// else goes through mux and gets requestTimeout
+	rootMux := http.NewServeMux()
+	rootMux.Handle("/", middlewareTimeout(mux, requestTimeout))
+
+	mux.Handle("/app/*", apiConfig.middlewareHitInc(http.StripPrefix("/app/", http.FileServer(http.Dir(filePathRoot)))))
+
+	mux.Handle("GET /api/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+	}))
+	mux.HandleFunc("GET /admin/metrics", apiConfig.handlerMetrics)
+	mux.HandleFunc("GET /api/reset", apiConfig.handlerReset)
+	mux.Handle("GET /admin/revocations", apiConfig.middlewareAdminAuth(http.HandlerFunc(apiConfig.handlerRevocations)))
+	mux.Handle("GET /admin/backup", apiConfig.middlewareAdminAuth(http.HandlerFunc(apiConfig.handlerBackup)))
+	mux.Handle("GET /admin/replication", apiConfig.middlewareAdminAuth(http.HandlerFunc(apiConfig.handlerReplicationStatus)))
+	mux.Handle("GET /admin/replication/snapshot", apiConfig.middlewareAdminAuth(http.HandlerFunc(apiConfig.handlerReplicationSnapshot)))
+	rootMux.Handle("GET /admin/replication/changes", apiConfig.middlewareAdminAuth(http.HandlerFunc(apiConfig.handlerReplicationChanges)))
+
+	mux.HandleFunc("POST /api/chirps", apiConfig.handlerCreateChirp)
+	mux.HandleFunc("GET /api/chirps", apiConfig.handlerGetChirps)
+	mux.HandleFunc("GET /api/chirps/search", apiConfig.handlerSearchChirps)
+	mux.HandleFunc("GET /api/chirps/{id}", apiConfig.handlerGetChirp)
+	mux.HandleFunc("PUT /api/chirps/{id}", apiConfig.handlerEditChirp)
+	mux.HandleFunc("DELETE /api/chirps/{id}", apiConfig.handlerDeleteChirp)
+	mux.HandleFunc("GET /api/chirps/{id}/history", apiConfig.handlerGetChirpHistory)
+	mux.HandleFunc("POST /api/chirps/{id}/restore", apiConfig.handlerRestoreChirp)
+	mux.HandleFunc("GET /api/chirps/{id}/thread", apiConfig.handlerGetThread)
+	mux.HandleFunc("POST /api/chirps/{id}/rechirp", apiConfig.handlerRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{id}/rechirp", apiConfig.handlerUnrechirp)
+	mux.HandleFunc("GET /api/chirps/{id}/rechirps", apiConfig.handlerGetRechirps)
+	mux.HandleFunc("PUT /api/chirps/{id}/like", apiConfig.handlerLikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{id}/like", apiConfig.handlerUnlikeChirp)
+	mux.HandleFunc("GET /api/chirps/{id}/likes", apiConfig.handlerGetChirpLikes)
+
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiConfig.handlerGetHashtagChirps)
+	mux.HandleFunc("GET /api/trending", apiConfig.handlerTrending)
+
+	mux.HandleFunc("POST /api/users", apiConfig.handlerCreateUser)
+	mux.HandleFunc("PUT /api/users", apiConfig.handlerUpdateUser)
+	mux.HandleFunc("GET /api/users/{id}/mentions", apiConfig.handlerGetUserMentions)
+	mux.HandleFunc("GET /api/users/{id}/likes", apiConfig.handlerGetUserLikes)
+
+	mux.HandleFunc("POST /api/login", apiConfig.handlerLogin)
+
+	mux.HandleFunc("POST /api/revoke", apiConfig.handlerRevokeToken)
+	mux.HandleFunc("POST /api/refresh", apiConfig.handlerRefreshToken)
+
+	mux.HandleFunc("POST /api/polka/webhooks", apiConfig.handlerWebhookUpgradeUser)
+
+	handler := middlewareCors(rootMux)
+	if apiConfig.replicator != nil {
+		handler = middlewareForwardWrites(apiConfig.replicator.leader, handler)
+	}
+	return handler
+}
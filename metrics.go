@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"time"
 )
 
 func (cfg *apiConfig) middlewareHitInc(next http.Handler) http.Handler {
@@ -10,3 +11,24 @@ func (cfg *apiConfig) middlewareHitInc(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (cfg *apiConfig) handlerRevocations(w http.ResponseWriter, r *http.Request) {
+	count, err := cfg.db.RevocationCount(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type response struct {
+		RevokedTokens   int        `json:"revoked_tokens"`
+		LastSweepAt     *time.Time `json:"last_sweep_at"`
+		LastSweepPurged int        `json:"last_sweep_purged"`
+	}
+	resp := response{RevokedTokens: count}
+	lastSweepAt, purged := cfg.sweeper.lastSweep()
+	if !lastSweepAt.IsZero() {
+		resp.LastSweepAt = &lastSweepAt
+		resp.LastSweepPurged = purged
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
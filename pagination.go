@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pager paginates queries of type Q returning results of type R, using the
+// limit and cursor query parameters.
+type pager[Q, R any] struct {
+	decodeCursor func(cursor string, query *Q) error
+	encodeCursor func(query Q, last R) string
+	setLimit     func(query *Q, limit int)
+}
+
+var (
+	chirpPager = pager[database.ChirpQuery, database.Chirp]{
+		decodeCursor: decodeChirpCursor,
+		encodeCursor: encodeChirpCursor,
+		setLimit:     func(query *database.ChirpQuery, limit int) { query.Limit = limit },
+	}
+	searchPager = pager[database.SearchQuery, database.SearchResult]{
+		decodeCursor: decodeSearchCursor,
+		encodeCursor: encodeSearchCursor,
+		setLimit:     func(query *database.SearchQuery, limit int) { query.Limit = limit },
+	}
+	likePager = pager[database.LikeQuery, database.Like]{
+		decodeCursor: decodeLikeCursor,
+		encodeCursor: encodeLikeCursor,
+		setLimit:     func(query *database.LikeQuery, limit int) { query.Limit = limit },
+	}
+)
+
+// prepare reads the limit and cursor parameters into query and returns the
+// page size. The query asks for one result more, which tells finish
+// whether there is a next page. Its errors are the client's fault.
+func (p pager[Q, R]) prepare(r *http.Request, query *Q) (int, error) {
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		return 0, err
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		err = p.decodeCursor(cursor, query)
+		if err != nil {
+			return 0, err
+		}
+	}
+	p.setLimit(query, limit+1)
+	return limit, nil
+}
+
+// finish cuts the results of a query set up by prepare down to the page
+// and, if there is a next one, links to it.
+func (p pager[Q, R]) finish(w http.ResponseWriter, r *http.Request, query Q, results []R, limit int) []R {
+	if len(results) > limit {
+		results = results[:limit]
+		setNextLink(w, r, p.encodeCursor(query, results[limit-1]))
+	}
+	return results
+}
+
+// chirpCursor is what an opaque cursor decodes to. It records the ordering
+// it was issued for, so a cursor can't be replayed against another one.
+type chirpCursor struct {
+	OrderBy    database.ChirpOrder `json:"o"`
+	Descending bool                `json:"d"`
+	ID         int                 `json:"i"`
+	CreatedAt  time.Time           `json:"t"`
+}
+
+func encodeChirpCursor(query database.ChirpQuery, last database.Chirp) string {
+	data, _ := json.Marshal(chirpCursor{
+		OrderBy:    query.OrderBy,
+		Descending: query.Descending,
+		ID:         last.ID,
+		CreatedAt:  last.CreatedAt,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeChirpCursor sets query.After from cursor.
+func decodeChirpCursor(cursor string, query *database.ChirpQuery) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errInvalidCursor
+	}
+	c := chirpCursor{}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return errInvalidCursor
+	}
+	if c.OrderBy != query.OrderBy || c.Descending != query.Descending {
+		return fmt.Errorf("%w: it was issued for a different sort order", errInvalidCursor)
+	}
+	query.After = &database.ChirpCursor{ID: c.ID, CreatedAt: c.CreatedAt}
+	return nil
+}
+
+// searchCursor is what an opaque search cursor decodes to. It is tied to
+// the search it was issued for, and carries the word weights its first page
+// was ranked with so later pages are ranked the same way.
+type searchCursor struct {
+	Query    string             `json:"q"`
+	AuthorID int                `json:"a"`
+	Weights  map[string]float64 `json:"w"`
+	Score    float64            `json:"s"`
+	ID       int                `json:"i"`
+}
+
+func encodeSearchCursor(query database.SearchQuery, last database.SearchResult) string {
+	data, _ := json.Marshal(searchCursor{
+		Query:    query.Text,
+		AuthorID: query.AuthorID,
+		Weights:  query.Weights,
+		Score:    last.Score,
+		ID:       last.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor sets query.After from cursor.
+func decodeSearchCursor(cursor string, query *database.SearchQuery) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errInvalidCursor
+	}
+	c := searchCursor{}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return errInvalidCursor
+	}
+	if c.Query != query.Text || c.AuthorID != query.AuthorID {
+		return fmt.Errorf("%w: it was issued for a different search", errInvalidCursor)
+	}
+	query.Weights = c.Weights
+	query.After = &database.SearchCursor{Score: c.Score, ID: c.ID}
+	return nil
+}
+
+// likeCursor is what an opaque likes cursor decodes to. It is tied to the
+// chirp or user whose likes it was issued for.
+type likeCursor struct {
+	ForChirp  int       `json:"fc"`
+	ForUser   int       `json:"fu"`
+	CreatedAt time.Time `json:"t"`
+	ChirpID   int       `json:"c"`
+	UserID    int       `json:"u"`
+}
+
+func encodeLikeCursor(query database.LikeQuery, last database.Like) string {
+	data, _ := json.Marshal(likeCursor{
+		ForChirp:  query.ChirpID,
+		ForUser:   query.UserID,
+		CreatedAt: last.CreatedAt,
+		ChirpID:   last.ChirpID,
+		UserID:    last.UserID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeLikeCursor sets query.After from cursor.
+func decodeLikeCursor(cursor string, query *database.LikeQuery) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errInvalidCursor
+	}
+	c := likeCursor{}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return errInvalidCursor
+	}
+	if c.ForChirp != query.ChirpID || c.ForUser != query.UserID {
+		return fmt.Errorf("%w: it was issued for a different list of likes", errInvalidCursor)
+	}
+	query.After = &database.LikeCursor{CreatedAt: c.CreatedAt, ChirpID: c.ChirpID, UserID: c.UserID}
+	return nil
+}
+
+// parsePageLimit reads the limit query parameter, defaulting to
+// defaultPageLimit.
+func parsePageLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	return limit, nil
+}
+
+// setNextLink points the Link header at the request's URL with its cursor
+// replaced by next.
+func setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.RequestURI()))
+}
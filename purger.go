@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+const (
+	// chirpRestoreWindow is how long a deleted chirp can be restored by its
+	// author before it is purged for good.
+	chirpRestoreWindow = 7 * 24 * time.Hour
+	chirpPurgeInterval = time.Hour
+)
+
+// chirpPurger periodically removes deleted chirps whose restore window has
+// passed.
+type chirpPurger struct {
+	db database.ChirpStore
+}
+
+func (p *chirpPurger) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.purge()
+		<-ticker.C
+	}
+}
+
+func (p *chirpPurger) purge() {
+	deletedBefore := time.Now().UTC().Add(-chirpRestoreWindow)
+	purged, err := p.db.PurgeDeletedChirps(context.Background(), deletedBefore)
+	if err != nil {
+		log.Printf("purging deleted chirps: %s", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purged %d deleted chirps", purged)
+	}
+}
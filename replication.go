@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+const (
+	// replicationMaxWait caps how long a follower's poll for changes is held
+	// open by the leader.
+	replicationMaxWait = 30 * time.Second
+	// replicationRetryInterval is how long a follower waits after a failed
+	// poll before trying again.
+	replicationRetryInterval = 5 * time.Second
+)
+
+func (cfg *apiConfig) handlerReplicationSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg.streamSnapshot(w, r, "replication snapshot")
+}
+
+func (cfg *apiConfig) handlerReplicationChanges(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid since")
+		return
+	}
+	var wait time.Duration
+	if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+		wait, err = time.ParseDuration(waitParam)
+		if err != nil || wait < 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid wait")
+			return
+		}
+	}
+	wait = min(wait, replicationMaxWait)
+
+	// the server's write timeout is shorter than a long poll
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+	if err != nil {
+		log.Printf("extending write deadline: %s", err)
+	}
+
+	changes, seq, err := cfg.db.Changes(r.Context(), since, wait)
+	if errors.Is(err, database.ErrChangesTruncated) {
+		respondWithError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil && r.Context().Err() != nil {
+		// the follower went away or we are shutting down
+		respondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type response struct {
+		Seq     uint64            `json:"seq"`
+		Changes []database.Change `json:"changes"`
+	}
+	if changes == nil {
+		changes = []database.Change{}
+	}
+	respondWithJSON(w, http.StatusOK, response{Seq: seq, Changes: changes})
+}
+
+func (cfg *apiConfig) handlerReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if cfg.replicator != nil {
+		respondWithJSON(w, http.StatusOK, cfg.replicator.status())
+		return
+	}
+
+	seq, err := cfg.db.Seq(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	type response struct {
+		Role string `json:"role"`
+		Seq  uint64 `json:"seq"`
+	}
+	respondWithJSON(w, http.StatusOK, response{Role: "leader", Seq: seq})
+}
+
+// middlewareForwardWrites sends every request that could change something to
+// the leader, so a follower's store is only ever written by replication.
+func middlewareForwardWrites(leader *url.URL, next http.Handler) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(leader)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			proxy.ServeHTTP(w, r)
+		}
+	})
+}
+
+type replicationStatus struct {
+	Role          string     `json:"role"`
+	Leader        string     `json:"leader"`
+	LeaderSeq     uint64     `json:"leader_seq"`
+	AppliedSeq    uint64     `json:"applied_seq"`
+	LagChanges    uint64     `json:"lag_changes"`
+	LagSeconds    float64    `json:"lag_seconds"`
+	LastContactAt *time.Time `json:"last_contact_at"`
+	LastAppliedAt *time.Time `json:"last_applied_at"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// replicator keeps a follower's store in step with the leader. It starts
+// from a snapshot, then long-polls the leader for changes and applies them
+// in order, falling back to a fresh snapshot whenever the leader can't
+// provide the changes it needs.
+type replicator struct {
+	db     database.ReplicationStore
+	leader *url.URL
+	apiKey string
+	client *http.Client
+
+	mux       sync.Mutex
+	leaderSeq uint64
+	applied   uint64
+	// caughtUpAt is the last time the follower had applied everything the
+	// leader had committed; lag in seconds is measured from it
+	caughtUpAt    time.Time
+	lastContactAt time.Time
+	lastAppliedAt time.Time
+	lastErr       error
+}
+
+func newReplicator(db database.ReplicationStore, leader *url.URL, apiKey string) *replicator {
+	return &replicator{
+		db:     db,
+		leader: leader,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: replicationMaxWait + 15*time.Second},
+	}
+}
+
+// run replicates until ctx is cancelled.
+func (rep *replicator) run(ctx context.Context) {
+	// the local store may be from another leader, or from this one before
+	// it was restored, so never trust it to continue from
+	needSnapshot := true
+	for ctx.Err() == nil {
+		var err error
+		if needSnapshot {
+			err = rep.resync(ctx)
+		} else {
+			err = rep.poll(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		needSnapshot = errors.Is(err, database.ErrChangesTruncated) ||
+			errors.Is(err, database.ErrChangeOutOfOrder) ||
+			needSnapshot && err != nil
+
+		rep.mux.Lock()
+		rep.lastErr = err
+		rep.mux.Unlock()
+		if err != nil && !errors.Is(err, database.ErrChangesTruncated) {
+			log.Printf("replication: %s", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(replicationRetryInterval):
+			}
+		}
+	}
+}
+
+func (rep *replicator) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := rep.leader.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "ApiKey "+rep.apiKey)
+	resp, err := rep.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, database.ErrChangesTruncated
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (rep *replicator) resync(ctx context.Context) error {
+	resp, err := rep.get(ctx, "/admin/replication/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = rep.db.ReplaceWithSnapshot(ctx, resp.Body)
+	if err != nil {
+		return err
+	}
+	seq, err := rep.db.Seq(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("replication: loaded snapshot at seq %d from %s", seq, rep.leader)
+
+	now := time.Now().UTC()
+	rep.mux.Lock()
+	defer rep.mux.Unlock()
+	rep.lastContactAt = now
+	// the snapshot is the leader's state as of now
+	rep.applied = seq
+	rep.leaderSeq = seq
+	rep.caughtUpAt = now
+	return nil
+}
+
+func (rep *replicator) poll(ctx context.Context) error {
+	seq, err := rep.db.Seq(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := rep.get(ctx, "/admin/replication/changes", url.Values{
+		"since": {strconv.FormatUint(seq, 10)},
+		"wait":  {replicationMaxWait.String()},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var batch struct {
+		Seq     uint64            `json:"seq"`
+		Changes []database.Change `json:"changes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&batch)
+	if err != nil {
+		return err
+	}
+
+	rep.mux.Lock()
+	rep.lastContactAt = time.Now().UTC()
+	rep.leaderSeq = batch.Seq
+	if rep.applied >= rep.leaderSeq {
+		rep.caughtUpAt = rep.lastContactAt
+	}
+	rep.mux.Unlock()
+
+	for _, change := range batch.Changes {
+		err := rep.db.ApplyChange(ctx, change)
+		if err != nil {
+			return err
+		}
+
+		rep.mux.Lock()
+		rep.applied = change.Seq
+		rep.lastAppliedAt = change.Time
+		if rep.applied >= rep.leaderSeq {
+			rep.caughtUpAt = time.Now().UTC()
+		}
+		rep.mux.Unlock()
+	}
+	return nil
+}
+
+func (rep *replicator) status() replicationStatus {
+	rep.mux.Lock()
+	defer rep.mux.Unlock()
+
+	s := replicationStatus{
+		Role:       "follower",
+		Leader:     rep.leader.String(),
+		LeaderSeq:  rep.leaderSeq,
+		AppliedSeq: rep.applied,
+	}
+	if rep.leaderSeq > rep.applied {
+		s.LagChanges = rep.leaderSeq - rep.applied
+		if !rep.caughtUpAt.IsZero() {
+			s.LagSeconds = time.Since(rep.caughtUpAt).Seconds()
+		}
+	}
+	if !rep.lastContactAt.IsZero() {
+		lastContactAt := rep.lastContactAt
+		s.LastContactAt = &lastContactAt
+	}
+	if !rep.lastAppliedAt.IsZero() {
+		lastAppliedAt := rep.lastAppliedAt
+		s.LastAppliedAt = &lastAppliedAt
+	}
+	if rep.lastErr != nil {
+		s.LastError = rep.lastErr.Error()
+	}
+	return s
+}
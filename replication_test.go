@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+const testAdminAPIKey = "admin-key"
+
+func newTestConfig(db database.Store) *apiConfig {
+	return &apiConfig{
+		db:          db,
+		jwtSecret:   "secretsecretsecretsecret",
+		adminAPIKey: testAdminAPIKey,
+		trending:    &trendingTracker{db: db},
+	}
+}
+
+// do sends a request with a JSON body to server, checks the response status
+// and decodes its body into out, if given.
+func do(t *testing.T, server *httptest.Server, method, path, header string, body, out any, wantStatus int) {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, server.URL+path, &reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: got %s, want %d", method, path, resp.Status, wantStatus)
+	}
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+}
+
+// signUp creates a user with email on server and returns an Authorization
+// header for them.
+func signUp(t *testing.T, server *httptest.Server, email string) string {
+	t.Helper()
+	user := map[string]string{"email": email, "password": "password"}
+	do(t, server, http.MethodPost, "/api/users", "", user, nil, http.StatusCreated)
+	var login struct {
+		Token string `json:"token"`
+	}
+	do(t, server, http.MethodPost, "/api/login", "", user, &login, http.StatusOK)
+	return "Bearer " + login.Token
+}
+
+// eventually fails the test if cond hasn't held within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicationBetweenLeaderAndFollower(t *testing.T) {
+	leaderCfg := newTestConfig(database.NewMemoryDB())
+	leader := httptest.NewServer(leaderCfg.handler())
+	t.Cleanup(leader.Close)
+
+	// the follower starts from a snapshot with what is already there...
+	bearer := signUp(t, leader, "a@example.com")
+	do(t, leader, http.MethodPost, "/api/chirps", bearer,
+		map[string]string{"body": "before the follower"}, nil, http.StatusCreated)
+
+	leaderURL, err := url.Parse(leader.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	followerDB := database.NewMemoryDB()
+	followerCfg := newTestConfig(followerDB)
+	followerCfg.replicator = newReplicator(followerDB, leaderURL, testAdminAPIKey)
+	follower := httptest.NewServer(followerCfg.handler())
+	t.Cleanup(follower.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		followerCfg.replicator.run(ctx)
+		close(stopped)
+	}()
+	// stop before the leader closes, which waits for the follower's long poll
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	chirpCount := func(server *httptest.Server) int {
+		var chirps []database.Chirp
+		do(t, server, http.MethodGet, "/api/chirps", "", nil, &chirps, http.StatusOK)
+		return len(chirps)
+	}
+	eventually(t, "the snapshot", func() bool { return chirpCount(follower) == 1 })
+
+	// ...then applies the leader's writes as they happen
+	do(t, leader, http.MethodPost, "/api/chirps", bearer,
+		map[string]string{"body": "after the follower"}, nil, http.StatusCreated)
+	eventually(t, "a change", func() bool { return chirpCount(follower) == 2 })
+
+	// writes sent to the follower are made on the leader
+	var forwarded database.Chirp
+	do(t, follower, http.MethodPost, "/api/chirps", bearer,
+		map[string]string{"body": "sent to the follower"}, &forwarded, http.StatusCreated)
+	_, err = leaderCfg.db.GetChirp(context.Background(), forwarded.ID)
+	if err != nil {
+		t.Fatalf("forwarded chirp on the leader: %s", err)
+	}
+	eventually(t, "the forwarded chirp", func() bool { return chirpCount(follower) == 3 })
+
+	var leaderStatus struct {
+		Role string `json:"role"`
+		Seq  uint64 `json:"seq"`
+	}
+	do(t, leader, http.MethodGet, "/admin/replication", "ApiKey "+testAdminAPIKey, nil, &leaderStatus, http.StatusOK)
+	if leaderStatus.Role != "leader" || leaderStatus.Seq == 0 {
+		t.Fatalf("leader status: %+v", leaderStatus)
+	}
+	var status replicationStatus
+	eventually(t, "the follower to report it has caught up", func() bool {
+		do(t, follower, http.MethodGet, "/admin/replication", "ApiKey "+testAdminAPIKey, nil, &status, http.StatusOK)
+		return status.AppliedSeq == leaderStatus.Seq
+	})
+	if status.Role != "follower" || status.Leader != leader.URL ||
+		status.LeaderSeq != leaderStatus.Seq || status.LagChanges != 0 || status.LagSeconds != 0 ||
+		status.LastContactAt == nil || status.LastAppliedAt == nil || status.LastError != "" {
+		t.Fatalf("follower status: %+v", status)
+	}
+}
+
+func TestReplicationStatusReportsLag(t *testing.T) {
+	leaderURL, err := url.Parse("http://leader.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.NewMemoryDB()
+	cfg := newTestConfig(db)
+	cfg.replicator = newReplicator(db, leaderURL, testAdminAPIKey)
+	cfg.replicator.leaderSeq = 7
+	cfg.replicator.applied = 4
+	cfg.replicator.caughtUpAt = time.Now().Add(-time.Minute)
+	cfg.replicator.lastErr = errors.New("connection refused")
+	server := httptest.NewServer(cfg.handler())
+	t.Cleanup(server.Close)
+
+	var status replicationStatus
+	do(t, server, http.MethodGet, "/admin/replication", "ApiKey "+testAdminAPIKey, nil, &status, http.StatusOK)
+	if status.LeaderSeq != 7 || status.AppliedSeq != 4 || status.LagChanges != 3 {
+		t.Fatalf("status: %+v", status)
+	}
+	if status.LagSeconds < 60 || status.LagSeconds > 120 {
+		t.Fatalf("lag_seconds = %v, want about a minute", status.LagSeconds)
+	}
+	if status.LastError != "connection refused" {
+		t.Fatalf("last_error = %q", status.LastError)
+	}
+}
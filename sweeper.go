@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+const revocationSweepInterval = time.Hour
+
+// revocationSweeper periodically drops revoked tokens that have expired
+// since, so the revocation list doesn't grow forever.
+type revocationSweeper struct {
+	db database.TokenStore
+
+	mux         sync.Mutex
+	lastSweepAt time.Time
+	lastPurged  int
+}
+
+func (s *revocationSweeper) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.sweep()
+		<-ticker.C
+	}
+}
+
+func (s *revocationSweeper) sweep() {
+	now := time.Now().UTC()
+	purged, err := s.db.PurgeExpiredRevocations(context.Background(), now)
+	if err != nil {
+		log.Printf("sweeping revoked tokens: %s", err)
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.lastSweepAt = now
+	s.lastPurged = purged
+}
+
+func (s *revocationSweeper) lastSweep() (time.Time, int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.lastSweepAt, s.lastPurged
+}
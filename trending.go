@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+const (
+	trendingInterval = time.Minute
+	// maxTrendingLimit is how many hashtags are kept per window, and so the
+	// largest limit GET /api/trending accepts.
+	maxTrendingLimit     = 100
+	defaultTrendingLimit = 10
+)
+
+// trendingWindows are the windows GET /api/trending can be asked for. The
+// first one is the default.
+var trendingWindows = []time.Duration{24 * time.Hour, time.Hour, 7 * 24 * time.Hour}
+
+type trendingHashtag struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+	// Authors is how many different users used the tag in the window,
+	// Chirps how many chirps did.
+	Authors int `json:"authors"`
+	Chirps  int `json:"chirps"`
+}
+
+// trendingTracker periodically ranks the hashtags used in each of the
+// trendingWindows, so requests only read the latest ranking.
+//
+// Each author contributes once per tag, with their latest use, so repeating
+// a tag doesn't push it up. A use counts for less the older it is, halving
+// every quarter of the window.
+type trendingTracker struct {
+	db database.ChirpStore
+
+	mux        sync.Mutex
+	computedAt time.Time
+	rankings   map[time.Duration][]trendingHashtag
+}
+
+func (t *trendingTracker) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		t.recompute()
+		<-ticker.C
+	}
+}
+
+func (t *trendingTracker) recompute() {
+	now := time.Now().UTC()
+	longest := slices.Max(trendingWindows)
+	uses, err := t.db.HashtagUses(context.Background(), now.Add(-longest))
+	if err != nil {
+		log.Printf("computing trending hashtags: %s", err)
+		return
+	}
+
+	rankings := make(map[time.Duration][]trendingHashtag, len(trendingWindows))
+	for _, window := range trendingWindows {
+		rankings[window] = rankHashtags(uses, now, window)
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.computedAt = now
+	t.rankings = rankings
+}
+
+func rankHashtags(uses []database.HashtagUse, now time.Time, window time.Duration) []trendingHashtag {
+	type tagAuthor struct {
+		tag      string
+		authorID int
+	}
+	latest := map[tagAuthor]time.Time{}
+	chirps := map[string]int{}
+	for _, use := range uses {
+		if use.At.Before(now.Add(-window)) {
+			continue
+		}
+		key := tagAuthor{use.Tag, use.AuthorID}
+		if use.At.After(latest[key]) {
+			latest[key] = use.At
+		}
+		chirps[use.Tag]++
+	}
+
+	halfLife := window / 4
+	byTag := map[string]*trendingHashtag{}
+	for key, at := range latest {
+		h := byTag[key.tag]
+		if h == nil {
+			h = &trendingHashtag{Tag: key.tag, Chirps: chirps[key.tag]}
+			byTag[key.tag] = h
+		}
+		age := max(now.Sub(at), 0)
+		h.Score += math.Exp2(-float64(age) / float64(halfLife))
+		h.Authors++
+	}
+
+	ranking := make([]trendingHashtag, 0, len(byTag))
+	for _, h := range byTag {
+		ranking = append(ranking, *h)
+	}
+	slices.SortFunc(ranking, func(a, b trendingHashtag) int {
+		if n := cmp.Compare(b.Score, a.Score); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+	if len(ranking) > maxTrendingLimit {
+		ranking = ranking[:maxTrendingLimit]
+	}
+	return ranking
+}
+
+func (t *trendingTracker) ranking(window time.Duration) ([]trendingHashtag, time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.rankings[window], t.computedAt
+}
+
+func (cfg *apiConfig) handlerTrending(w http.ResponseWriter, r *http.Request) {
+	window := trendingWindows[0]
+	if param := r.URL.Query().Get("window"); param != "" {
+		d, err := time.ParseDuration(param)
+		if err != nil || !slices.Contains(trendingWindows, d) {
+			respondWithError(w, http.StatusBadRequest, "window must be one of 1h, 24h or 168h")
+			return
+		}
+		window = d
+	}
+	limit := defaultTrendingLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 || n > maxTrendingLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxTrendingLimit))
+			return
+		}
+		limit = n
+	}
+
+	ranking, computedAt := cfg.trending.ranking(window)
+	if computedAt.IsZero() {
+		respondWithError(w, http.StatusServiceUnavailable, "trending hashtags are still being computed")
+		return
+	}
+	ranking = ranking[:min(limit, len(ranking))]
+
+	type response struct {
+		Window     string            `json:"window"`
+		ComputedAt time.Time         `json:"computed_at"`
+		Hashtags   []trendingHashtag `json:"hashtags"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Window:     window.String(),
+		ComputedAt: computedAt,
+		Hashtags:   ranking,
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ammon134/chirpy/internal/database"
+)
+
+func TestRankHashtags(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	// a four hour window halves a use's weight every hour
+	const window = 4 * time.Hour
+	use := func(tag string, authorID int, ago time.Duration) database.HashtagUse {
+		return database.HashtagUse{Tag: tag, AuthorID: authorID, At: now.Add(-ago)}
+	}
+	var manyTags []database.HashtagUse
+	for i := range maxTrendingLimit + 20 {
+		manyTags = append(manyTags, use(fmt.Sprintf("tag%03d", i), 1, time.Duration(i)*time.Minute))
+	}
+
+	tests := []struct {
+		name string
+		uses []database.HashtagUse
+		want []trendingHashtag
+	}{
+		{
+			name: "recent uses outweigh older ones",
+			uses: []database.HashtagUse{
+				use("old", 1, 3*time.Hour), use("old", 2, 3*time.Hour), use("old", 3, 3*time.Hour),
+				use("new", 4, 0),
+				use("older", 5, 2*time.Hour),
+			},
+			want: []trendingHashtag{
+				{Tag: "new", Score: 1, Authors: 1, Chirps: 1},
+				{Tag: "old", Score: 0.375, Authors: 3, Chirps: 3},
+				{Tag: "older", Score: 0.25, Authors: 1, Chirps: 1},
+			},
+		},
+		{
+			name: "equal scores rank by tag",
+			uses: []database.HashtagUse{use("b", 1, time.Hour), use("a", 2, time.Hour)},
+			want: []trendingHashtag{
+				{Tag: "a", Score: 0.5, Authors: 1, Chirps: 1},
+				{Tag: "b", Score: 0.5, Authors: 1, Chirps: 1},
+			},
+		},
+		{
+			name: "an author counts once with their latest use",
+			uses: []database.HashtagUse{
+				use("spam", 1, 3*time.Hour), use("spam", 1, 0), use("spam", 1, 0), use("spam", 1, time.Hour),
+				use("real", 2, time.Hour), use("real", 3, time.Hour), use("real", 4, time.Hour),
+			},
+			want: []trendingHashtag{
+				{Tag: "real", Score: 1.5, Authors: 3, Chirps: 3},
+				{Tag: "spam", Score: 1, Authors: 1, Chirps: 4},
+			},
+		},
+		{
+			name: "uses before the window are left out",
+			uses: []database.HashtagUse{
+				use("edge", 1, window),
+				use("gone", 2, window+time.Second),
+				use("edge", 3, window+time.Second),
+			},
+			want: []trendingHashtag{
+				{Tag: "edge", Score: 1.0 / 16, Authors: 1, Chirps: 1},
+			},
+		},
+		{
+			name: "uses after now count in full",
+			uses: []database.HashtagUse{use("clock", 1, -time.Minute)},
+			want: []trendingHashtag{
+				{Tag: "clock", Score: 1, Authors: 1, Chirps: 1},
+			},
+		},
+		{
+			name: "no uses",
+			want: []trendingHashtag{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rankHashtags(tt.uses, now, window)
+			if !sameRanking(got, tt.want) {
+				t.Errorf("rankHashtags = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("only the top maxTrendingLimit are kept", func(t *testing.T) {
+		got := rankHashtags(manyTags, now, window)
+		if len(got) != maxTrendingLimit {
+			t.Fatalf("got %d hashtags, want %d", len(got), maxTrendingLimit)
+		}
+		for i, h := range got {
+			if want := fmt.Sprintf("tag%03d", i); h.Tag != want {
+				t.Fatalf("hashtag %d is %s, want %s", i, h.Tag, want)
+			}
+		}
+	})
+}
+
+func sameRanking(got, want []trendingHashtag) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].Tag != want[i].Tag || got[i].Authors != want[i].Authors || got[i].Chirps != want[i].Chirps ||
+			math.Abs(got[i].Score-want[i].Score) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTrendingLimit(t *testing.T) {
+	cfg := newTestConfig(database.NewMemoryDB())
+	ranking := make([]trendingHashtag, 0, maxTrendingLimit)
+	for i := range maxTrendingLimit {
+		ranking = append(ranking, trendingHashtag{Tag: fmt.Sprintf("tag%03d", i)})
+	}
+	cfg.trending.computedAt = time.Now()
+	cfg.trending.rankings = map[time.Duration][]trendingHashtag{trendingWindows[0]: ranking}
+	server := httptest.NewServer(cfg.handler())
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantLen    int
+	}{
+		{"", http.StatusOK, defaultTrendingLimit},
+		{"?limit=1", http.StatusOK, 1},
+		{fmt.Sprintf("?limit=%d", maxTrendingLimit), http.StatusOK, maxTrendingLimit},
+		{"?limit=0", http.StatusBadRequest, 0},
+		{fmt.Sprintf("?limit=%d", maxTrendingLimit+1), http.StatusBadRequest, 0},
+		{"?limit=ten", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var resp struct {
+				Hashtags []trendingHashtag `json:"hashtags"`
+			}
+			var out any
+			if tt.wantStatus == http.StatusOK {
+				out = &resp
+			}
+			do(t, server, http.MethodGet, "/api/trending"+tt.query, "", nil, out, tt.wantStatus)
+			if len(resp.Hashtags) != tt.wantLen {
+				t.Fatalf("got %d hashtags, want %d", len(resp.Hashtags), tt.wantLen)
+			}
+			if tt.wantLen > 0 && resp.Hashtags[0].Tag != "tag000" {
+				t.Fatalf("first hashtag is %s, want the top ranked", resp.Hashtags[0].Tag)
+			}
+		})
+	}
+}